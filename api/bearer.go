@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/anduintransaction/oauth-proxy/service"
+	"gottb.io/goru"
+	"gottb.io/gorux"
+)
+
+const (
+	grpcUnauthenticated = "16"
+)
+
+// RenderUnauthenticated rejects a client without a session, as a gRPC status for gRPC calls.
+func RenderUnauthenticated(ctx *goru.Context) {
+	if service.IsGRPC(ctx.Request) {
+		header := ctx.ResponseWriter.Header()
+		header.Set("Content-Type", "application/grpc")
+		header.Set("Grpc-Status", grpcUnauthenticated)
+		header.Set("Grpc-Message", "unauthenticated")
+		ctx.ResponseWriter.WriteHeader(http.StatusOK)
+		return
+	}
+	ctx.ResponseWriter.Header().Set("WWW-Authenticate", "Bearer")
+	gorux.ResponseJSON(ctx, http.StatusUnauthorized, Error("unauthorized"))
+}
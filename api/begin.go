@@ -18,7 +18,7 @@ func Begin(ctx *goru.Context) {
 		gorux.ResponseJSON(ctx, http.StatusOK, Error("Anduin OAUTH proxy version "+service.Version()))
 		return
 	}
-	user := service.CheckSession(ctx)
+	user := service.CheckSession(ctx, p)
 	if user != nil {
 		goru.Redirect(ctx, "/")
 		return
@@ -27,10 +27,16 @@ func Begin(ctx *goru.Context) {
 	if requestPath == "" {
 		requestPath = "/"
 	}
+	if !service.IsSafeRedirect(requestPath, p.RedirectDomains) {
+		log.Errorf("Rejected request path: %s", requestPath)
+		gorux.ResponseJSON(ctx, http.StatusBadRequest, Error("Invalid request URL"))
+		return
+	}
 	requestURL, err := url.Parse(requestPath)
 	if err != nil {
 		log.Error(errors.Wrap(err))
 		gorux.ResponseJSON(ctx, http.StatusBadRequest, Error("Invalid request URL"))
+		return
 	}
 	ctx.Request.URL = requestURL
 	service.DoRedirect(ctx, p)
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"gottb.io/goru"
+	"gottb.io/goru/config/toml"
+)
+
+const beginConfig = `
+[oauth]
+provider = "github"
+client_id = "client"
+client_secret = "secret"
+callback_uri = "/oauth2/callback"
+state_timeout = 3600
+cookie_timeout = 3600
+cookie_name = "oauth-proxy"
+
+[[proxy]]
+scheme = "http"
+request_host = "www.your.server"
+end_point = "http://127.0.0.1:1"
+redirect_domains = ["www.your.server"]
+`
+
+func TestBeginRejectsInvalidRequestPath(t *testing.T) {
+	conf, err := toml.Build(strings.NewReader(beginConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = proxy.Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Stop(conf)
+	tests := []string{
+		"/%zz",
+		"//evil.com",
+		"/\\evil.com",
+		"/path\r\nLocation: https://evil.com",
+		"https://evil.com",
+	}
+	for _, requestPath := range tests {
+		values := url.Values{"request-path": {requestPath}}
+		request := httptest.NewRequest("GET", "http://www.your.server/oauth2/begin?"+values.Encode(), nil)
+		recorder := httptest.NewRecorder()
+		Begin(&goru.Context{Request: request, ResponseWriter: recorder})
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Begin(%q) status = %d, want %d", requestPath, recorder.Code, http.StatusBadRequest)
+		}
+		if location := recorder.Header().Get("Location"); location != "" {
+			t.Errorf("Begin(%q) redirected to %s", requestPath, location)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package api
 
 import (
-	"net/url"
-
 	"github.com/anduintransaction/oauth-proxy/provider"
 	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/service"
 
 	"gottb.io/goru"
 	"gottb.io/goru/log"
@@ -22,6 +21,10 @@ func Callback(ctx *goru.Context) {
 		RenderError(ctx, "State not found or expired")
 		return
 	}
+	if !checkLoginNonce(ctx, state) {
+		RenderError(ctx, "Login was started in another browser")
+		return
+	}
 	prov := provider.GetProvider(state.Proxy.Provider)
 	if prov == nil {
 		log.Errorf("Provider not found: %s", state.Proxy.Provider)
@@ -50,15 +53,44 @@ func Callback(ctx *goru.Context) {
 		RenderError(ctx, "Unauthorized user")
 		return
 	}
+	user.Token = token
+	user.ClientID = state.Proxy.ClientID
+
+	if canSetSession(ctx, state) {
+		proxy.AcquireState(stateName)
+		service.ClearLoginNonce(ctx, state.Proxy, stateName)
+		err = service.SetSession(ctx, state.Proxy, user)
+		if err != nil {
+			log.Error(err)
+			RenderError(ctx, InternalServerError.Message)
+			return
+		}
+		goru.Redirect(ctx, service.LoginRedirect(state, true))
+		return
+	}
 
 	state.User = user
-	redirectURL := url.URL{
-		Scheme: state.Proxy.Scheme,
-		Host:   state.Proxy.RequestHost,
-		Path:   "/oauth2/login",
+	loginProxy := proxy.AuthProxy()
+	if loginProxy == nil {
+		loginProxy = state.Proxy
+	}
+	goru.Redirect(ctx, service.AuthURL(loginProxy, "/oauth2/login", stateName))
+}
+
+func canSetSession(ctx *goru.Context, state *proxy.State) bool {
+	if proxy.Config.SSO && state.Proxy.CookieCovers(ctx.Request.Host) {
+		return true
+	}
+	return proxy.AuthProxy() == nil && proxy.SameHost(ctx.Request.Host, state.Proxy.RequestHost)
+}
+
+func checkLoginNonce(ctx *goru.Context, state *proxy.State) bool {
+	authProxy := proxy.AuthProxy()
+	if authProxy != nil && authProxy.CookieCovers(ctx.Request.Host) {
+		return service.CheckLoginNonce(ctx, authProxy, state.Name)
+	}
+	if state.Proxy.CookieCovers(ctx.Request.Host) {
+		return service.CheckLoginNonce(ctx, state.Proxy, state.Name)
 	}
-	values := make(url.Values)
-	values.Set("state", stateName)
-	redirectURL.RawQuery = values.Encode()
-	goru.Redirect(ctx, redirectURL.String())
+	return true
 }
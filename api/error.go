@@ -18,6 +18,14 @@ func Error(message string) *ErrorMessage {
 
 var InternalServerError = Error("internal server error")
 
+// Panic lets net/http abort a cut upstream stream and reports other panics as 500.
+func Panic(ctx *goru.Context) {
+	if ctx.Error != nil && ctx.Error.Underlying().Error() == http.ErrAbortHandler.Error() {
+		panic(http.ErrAbortHandler)
+	}
+	http.Error(ctx.ResponseWriter, "500 Internal Server Error", http.StatusInternalServerError)
+}
+
 func RenderError(ctx *goru.Context, message string) {
 	b, err := views.Error.Render(message)
 	if err != nil {
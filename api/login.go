@@ -1,16 +1,10 @@
 package api
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"net/http"
-	"time"
-
 	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/service"
 
 	"gottb.io/goru"
-	"gottb.io/goru/crypto"
-	"gottb.io/goru/errors"
 	"gottb.io/goru/log"
 	"gottb.io/gorux"
 )
@@ -21,7 +15,20 @@ func Login(ctx *goru.Context) {
 		RenderError(ctx, "State is required")
 		return
 	}
-	state := proxy.AcquireState(stateName)
+	if proxy.IsAuthHost(ctx.Request.Host) {
+		authLogin(ctx, stateName)
+		return
+	}
+	state := proxy.GetState(stateName)
+	if state == nil {
+		RenderError(ctx, "State not found or expired")
+		return
+	}
+	if !service.CheckLoginNonce(ctx, state.Proxy, stateName) {
+		RenderError(ctx, "Login was started in another browser")
+		return
+	}
+	state = proxy.AcquireState(stateName)
 	if state == nil {
 		RenderError(ctx, "State not found or expired")
 		return
@@ -30,28 +37,36 @@ func Login(ctx *goru.Context) {
 		RenderError(ctx, "User was not authenticated")
 		return
 	}
-	session := &proxy.Session{
-		User:    state.User,
-		Version: proxy.Config.Version,
-	}
-	cookieContent, err := json.Marshal(session)
+	service.ClearLoginNonce(ctx, state.Proxy, stateName)
+	err := service.SetSession(ctx, state.Proxy, state.User)
 	if err != nil {
-		log.Error(errors.Wrap(err))
+		log.Error(err)
 		RenderError(ctx, InternalServerError.Message)
 		return
 	}
-	encryptedContent, err := crypto.Encrypt(cookieContent)
+	goru.Redirect(ctx, service.LoginRedirect(state, false))
+}
+
+func authLogin(ctx *goru.Context, stateName string) {
+	state := proxy.GetState(stateName)
+	if state == nil {
+		RenderError(ctx, "State not found or expired")
+		return
+	}
+	if state.User == nil {
+		RenderError(ctx, "User was not authenticated")
+		return
+	}
+	if !service.CheckLoginNonce(ctx, proxy.AuthProxy(), stateName) {
+		RenderError(ctx, "Login was started in another browser")
+		return
+	}
+	service.ClearLoginNonce(ctx, proxy.AuthProxy(), stateName)
+	err := service.SetSession(ctx, proxy.AuthProxy(), state.User)
 	if err != nil {
 		log.Error(err)
 		RenderError(ctx, InternalServerError.Message)
 		return
 	}
-	goru.SetCookie(ctx, &http.Cookie{
-		Domain:  state.Proxy.RequestHost,
-		Name:    proxy.Config.CookieName,
-		Value:   base64.StdEncoding.EncodeToString(encryptedContent),
-		Path:    "/",
-		Expires: time.Now().Add(time.Duration(proxy.Config.CookieTimeout) * time.Second),
-	})
-	goru.Redirect(ctx, state.Request.URL.String())
+	service.IssueTicket(ctx, state)
 }
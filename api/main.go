@@ -18,12 +18,26 @@ func Main(ctx *goru.Context) {
 		return
 	}
 	if service.CheckWhitelist(ctx, p) {
-		service.ReverseProxy(ctx, p, nil)
+		reverseProxy(ctx, p, nil)
 		return
 	}
-	user := service.CheckSession(ctx)
+	token := service.BearerToken(ctx, p)
+	if token != "" {
+		user := service.CheckBearerToken(ctx, p, token)
+		if user == nil {
+			RenderUnauthenticated(ctx)
+			return
+		}
+		reverseProxy(ctx, p, user)
+		return
+	}
+	user := service.CheckSession(ctx, p)
 	if user != nil {
-		service.ReverseProxy(ctx, p, user)
+		reverseProxy(ctx, p, user)
+		return
+	}
+	if service.IsGRPC(ctx.Request) {
+		RenderUnauthenticated(ctx)
 		return
 	}
 	content, err := views.Index.Render(ctx.Request.URL.String())
@@ -40,9 +54,9 @@ func Favicon(ctx *goru.Context) {
 		gorux.ResponseJSON(ctx, http.StatusNotFound, Error("not found"))
 		return
 	}
-	user := service.CheckSession(ctx)
+	user := service.CheckSession(ctx, p)
 	if user != nil {
-		service.ReverseProxy(ctx, p, user)
+		reverseProxy(ctx, p, user)
 		return
 	}
 	gorux.ResponseJSON(ctx, http.StatusNotFound, Error("not found"))
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/service"
+	"gottb.io/goru"
+	"gottb.io/gorux"
+)
+
+func SignOut(ctx *goru.Context) {
+	p := proxy.GetProxy(ctx.Request.Host)
+	if p == nil && proxy.IsAuthHost(ctx.Request.Host) {
+		p = proxy.AuthProxy()
+	}
+	if p == nil {
+		gorux.ResponseJSON(ctx, http.StatusNotFound, Error("not found"))
+		return
+	}
+	if ctx.Request.Method == http.MethodPost {
+		service.RevokeToken(p, service.SessionUser(ctx, p))
+	}
+	service.ClearSession(ctx, p)
+	target := service.SignOutTarget(gorux.Query(ctx, "rd"))
+	authProxy := proxy.AuthProxy()
+	if authProxy != nil && authProxy != p {
+		signOutURL := url.URL{
+			Scheme: authProxy.Scheme,
+			Host:   authProxy.RequestHost,
+			Path:   "/oauth2/sign_out",
+		}
+		values := make(url.Values)
+		values.Set("rd", absoluteURL(p, target))
+		signOutURL.RawQuery = values.Encode()
+		target = signOutURL.String()
+	}
+	goru.Redirect(ctx, target)
+}
+
+func absoluteURL(p *proxy.Proxy, target string) string {
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.IsAbs() {
+		return target
+	}
+	targetURL.Scheme = p.Scheme
+	targetURL.Host = p.RequestHost
+	return targetURL.String()
+}
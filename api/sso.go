@@ -0,0 +1,69 @@
+package api
+
+import (
+	"github.com/anduintransaction/oauth-proxy/provider"
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/service"
+
+	"gottb.io/goru"
+	"gottb.io/goru/log"
+	"gottb.io/gorux"
+)
+
+func SSO(ctx *goru.Context) {
+	if !proxy.IsAuthHost(ctx.Request.Host) {
+		RenderError(ctx, "Invalid authentication host")
+		return
+	}
+	stateName := gorux.Query(ctx, "state")
+	if stateName == "" {
+		RenderError(ctx, "State is required")
+		return
+	}
+	state := proxy.GetState(stateName)
+	if state == nil {
+		RenderError(ctx, "State not found or expired")
+		return
+	}
+	user := service.CheckAuthSession(ctx, proxy.AuthProxy())
+	if user != nil && state.Proxy.AccessFor(state.Request.URL.Path).Authorize(user) {
+		log.Debugf("Reusing session on auth host for %s", state.Proxy.RequestHost)
+		state.User = user
+		service.IssueTicket(ctx, state)
+		return
+	}
+	prov := provider.GetProvider(state.Proxy.Provider)
+	if prov == nil {
+		log.Errorf("Provider not found: %s", state.Proxy.Provider)
+		RenderError(ctx, InternalServerError.Message)
+		return
+	}
+	service.SetLoginNonce(ctx, proxy.AuthProxy(), stateName)
+	goru.Redirect(ctx, prov.RedirectURI(state))
+}
+
+func Ticket(ctx *goru.Context) {
+	value := gorux.Query(ctx, "ticket")
+	if value == "" {
+		RenderError(ctx, "Ticket is required")
+		return
+	}
+	state, err := service.RedeemTicket(value, ctx.Request.Host)
+	if err != nil {
+		log.Error(err)
+		RenderError(ctx, "Invalid or expired ticket")
+		return
+	}
+	if !service.CheckLoginNonce(ctx, state.Proxy, state.Name) {
+		RenderError(ctx, "Login was started in another browser")
+		return
+	}
+	service.ClearLoginNonce(ctx, state.Proxy, state.Name)
+	err = service.SetSession(ctx, state.Proxy, state.User)
+	if err != nil {
+		log.Error(err)
+		RenderError(ctx, InternalServerError.Message)
+		return
+	}
+	goru.Redirect(ctx, service.LoginRedirect(state, false))
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/service"
+	"gottb.io/goru"
+	"gottb.io/gorux"
+)
+
+func Upstreams(ctx *goru.Context) {
+	p := proxy.GetProxy(ctx.Request.Host)
+	if p == nil || !proxy.AdminEnabled() {
+		gorux.ResponseJSON(ctx, http.StatusNotFound, Error("not found"))
+		return
+	}
+	user := service.CheckSession(ctx, p)
+	if user == nil {
+		gorux.ResponseJSON(ctx, http.StatusUnauthorized, Error("unauthorized"))
+		return
+	}
+	if !proxy.IsAdmin(user) {
+		gorux.ResponseJSON(ctx, http.StatusForbidden, Error("forbidden"))
+		return
+	}
+	gorux.ResponseJSON(ctx, http.StatusOK, p.UpstreamStatus())
+}
+
+func reverseProxy(ctx *goru.Context, p *proxy.Proxy, user *proxy.UserInfo) {
+	if !p.Available(ctx.Request.URL.Path) {
+		p.RenderError(ctx.ResponseWriter, ctx.Request, http.StatusServiceUnavailable)
+		return
+	}
+	service.ReverseProxy(ctx, p, user)
+}
@@ -4,6 +4,8 @@ package main
 import (
 	"github.com/anduintransaction/oauth-proxy/api"
 	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/secure"
+	"github.com/anduintransaction/oauth-proxy/server"
 	"gottb.io/goru"
 	"gottb.io/goru/crypto"
 	"gottb.io/goru/log"
@@ -17,14 +19,24 @@ func main() {
 	r.Get("/oauth2/callback", goru.HandlerFunc(api.Callback))
 	r.Get("/oauth2/login", goru.HandlerFunc(api.Login))
 	r.Get("/oauth2/begin", goru.HandlerFunc(api.Begin))
+	r.Get("/oauth2/sso", goru.HandlerFunc(api.SSO))
+	r.Get("/oauth2/ticket", goru.HandlerFunc(api.Ticket))
+	r.Get("/oauth2/sign_out", goru.HandlerFunc(api.SignOut))
+	r.Post("/oauth2/sign_out", goru.HandlerFunc(api.SignOut))
+	r.Get("/oauth2/upstreams", goru.HandlerFunc(api.Upstreams))
 	r.Get("/favicon.ico", goru.HandlerFunc(api.Favicon))
+	r.SetPanicHandler(goru.HandlerFunc(api.Panic))
+	server.Handle(r)
 
 	goru.StartWith(log.Start)
 	goru.StartWith(crypto.Start)
+	goru.StartWith(secure.Start)
 	goru.StartWith(session.Start)
 	goru.StartWith(proxy.Start)
+	goru.StartWith(server.Start)
 
 	goru.StopWith(log.Stop)
+	goru.StopWith(server.Stop)
 	goru.StopWith(proxy.Stop)
 	goru.Run(r)
 }
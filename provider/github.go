@@ -1,9 +1,11 @@
 package provider
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"gottb.io/goru/errors"
 	"gottb.io/goru/log"
@@ -16,21 +18,33 @@ const (
 	githubDefaultRedirectURI     = "https://github.com/login/oauth/authorize"
 	githubDefaultTokenRequestURI = "https://github.com/login/oauth/access_token"
 	githubDefaultAPIURI          = "https://api.github.com"
+	githubDefaultScope           = "user:email,read:org"
 )
 
 type GithubProvider struct {
 }
 
-func (p *GithubProvider) RedirectURI(proxy *proxy.Proxy, randomState string) string {
+func (p *GithubProvider) RedirectURI(state *proxy.State) string {
 	v := url.Values{}
-	v.Add("client_id", proxy.ClientID)
-	v.Add("redirect_uri", proxy.CallbackURI)
-	v.Add("scope", "user:email,read:org")
-	v.Add("state", randomState)
+	v.Add("client_id", state.Proxy.ClientID)
+	v.Add("redirect_uri", state.Proxy.CallbackURI)
+	v.Add("scope", p.scope(state.Proxy))
+	v.Add("state", state.Name)
 	v.Add("allow_signup", "false")
+	if state.CodeVerifier != "" {
+		v.Add("code_challenge", CodeChallenge(state.CodeVerifier))
+		v.Add("code_challenge_method", "S256")
+	}
 	return githubDefaultRedirectURI + "?" + v.Encode()
 }
 
+func (p *GithubProvider) scope(proxy *proxy.Proxy) string {
+	if len(proxy.Scopes) == 0 {
+		return githubDefaultScope
+	}
+	return strings.Join(proxy.Scopes, ",")
+}
+
 func (p *GithubProvider) ErrorString(request *http.Request) string {
 	return request.URL.Query().Get("error_description")
 }
@@ -38,16 +52,18 @@ func (p *GithubProvider) ErrorString(request *http.Request) string {
 func (p *GithubProvider) RequestToken(state *proxy.State, code string) (string, error) {
 	tokenRequest := &struct {
 		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
+		ClientSecret string `json:"client_secret,omitempty"`
 		Code         string `json:"code"`
 		RedirectURI  string `json:"redirect_uri"`
 		State        string `json:"state"`
+		CodeVerifier string `json:"code_verifier,omitempty"`
 	}{
 		ClientID:     state.Proxy.ClientID,
 		ClientSecret: state.Proxy.ClientSecret,
 		Code:         code,
-		RedirectURI:  state.Proxy.RedirectURI,
+		RedirectURI:  state.Proxy.CallbackURI,
 		State:        state.Name,
+		CodeVerifier: state.CodeVerifier,
 	}
 	statusCode, responseContent, err := utils.HTTPRequestJSON("POST", githubDefaultTokenRequestURI, tokenRequest, nil)
 	if err != nil {
@@ -71,27 +87,83 @@ func (p *GithubProvider) RequestToken(state *proxy.State, code string) (string,
 }
 
 func (p *GithubProvider) VerifyUser(state *proxy.State, token string) (*proxy.UserInfo, error) {
+	user, err := p.GetUser(token)
+	if err != nil {
+		return nil, err
+	}
+	access := state.Proxy.AccessFor(state.Request.URL.Path)
+	if !access.HasAnyOrg(user.Organizations) {
+		return nil, errors.Errorf("no suitable organization")
+	}
+	if !access.HasAnyTeam(user.Teams) {
+		return nil, errors.Errorf("no suitable team")
+	}
+	return user, nil
+}
+
+func (p *GithubProvider) GetUser(token string) (*proxy.UserInfo, error) {
 	user, err := p.getUserInfo(token)
 	if err != nil {
 		return nil, err
 	}
-	hasOrg, err := p.verifyOrg(state, token)
+	user.Organizations, err = p.getOrgs(token)
 	if err != nil {
 		return nil, err
 	}
-	if !hasOrg {
-		return nil, errors.Errorf("no suitable organization")
+	if !proxy.UsesTeams() {
+		return user, nil
 	}
-	hasTeam, err := p.verifyTeam(state, token)
+	user.Teams, err = p.getTeams(token)
 	if err != nil {
 		return nil, err
 	}
-	if !hasTeam {
-		return nil, errors.Errorf("no suitable team")
-	}
 	return user, nil
 }
 
+func (p *GithubProvider) RevokeToken(proxy *proxy.Proxy, token string) error {
+	request := &struct {
+		AccessToken string `json:"access_token"`
+	}{
+		AccessToken: token,
+	}
+	headers := map[string]string{
+		"Authorization": clientAuthorization(proxy),
+	}
+	statusCode, responseContent, err := utils.HTTPRequestJSON("DELETE", githubDefaultAPIURI+"/applications/"+proxy.ClientID+"/grant", request, headers)
+	if err != nil {
+		return err
+	}
+	if statusCode >= 300 {
+		log.Errorf("Cannot revoke grant for %s: %s", proxy.RequestHost, string(responseContent))
+		return errors.Errorf("invalid status code: %d", statusCode)
+	}
+	log.Infof("Revoked grant for %s", proxy.RequestHost)
+	return nil
+}
+
+func (p *GithubProvider) CheckToken(proxy *proxy.Proxy, token string) error {
+	request := &struct {
+		AccessToken string `json:"access_token"`
+	}{
+		AccessToken: token,
+	}
+	headers := map[string]string{
+		"Authorization": clientAuthorization(proxy),
+	}
+	statusCode, _, err := utils.HTTPRequestJSON("POST", githubDefaultAPIURI+"/applications/"+proxy.ClientID+"/token", request, headers)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return errors.Errorf("token not issued to %s, status code: %d", proxy.ClientID, statusCode)
+	}
+	return nil
+}
+
+func clientAuthorization(proxy *proxy.Proxy) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.ClientID+":"+proxy.ClientSecret))
+}
+
 func (p *GithubProvider) getUserInfo(token string) (*proxy.UserInfo, error) {
 	headers := map[string]string{
 		"Authorization": "token " + token,
@@ -114,17 +186,17 @@ func (p *GithubProvider) getUserInfo(token string) (*proxy.UserInfo, error) {
 	return user, nil
 }
 
-func (p *GithubProvider) verifyOrg(state *proxy.State, token string) (bool, error) {
+func (p *GithubProvider) getOrgs(token string) ([]string, error) {
 	headers := map[string]string{
 		"Authorization": "token " + token,
 	}
 	statusCode, responseContent, err := utils.HTTPRequestJSON("GET", githubDefaultAPIURI+"/user/orgs", "", headers)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if statusCode >= 300 {
 		log.Errorf("Invalid status code %d for token %s", statusCode, token)
-		return false, errors.Errorf("invalid status code: %d", statusCode)
+		return nil, errors.Errorf("invalid status code: %d", statusCode)
 	}
 	orgResponse := []struct {
 		Login string `json:"login"`
@@ -132,51 +204,40 @@ func (p *GithubProvider) verifyOrg(state *proxy.State, token string) (bool, erro
 	err = json.Unmarshal(responseContent, &orgResponse)
 	if err != nil {
 		log.Errorf("Cannot decode json: %s", string(responseContent))
-		return false, errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 	log.Infof("Organizations of %s: %v", token, orgResponse)
-	hasOrg := false
+	orgs := []string{}
 	for _, org := range orgResponse {
-		if state.Proxy.HasOrg(org.Login) {
-			log.Infof("Found organization: %s", org.Login)
-			hasOrg = true
-			break
-		}
+		orgs = append(orgs, org.Login)
 	}
-	return hasOrg, nil
+	return orgs, nil
 }
 
-func (p *GithubProvider) verifyTeam(state *proxy.State, token string) (bool, error) {
-	hasTeam := true
-	if len(state.Proxy.Teams) > 0 {
-		hasTeam = false
-		headers := map[string]string{
-			"Authorization": "token " + token,
-		}
-		statusCode, responseContent, err := utils.HTTPRequestJSON("GET", githubDefaultAPIURI+"/user/teams", "", headers)
-		if err != nil {
-			return false, err
-		}
-		if statusCode >= 300 {
-			log.Errorf("Invalid status code %d for token %s", statusCode, token)
-			return false, errors.Errorf("invalid status code: %d", statusCode)
-		}
-		teamResponse := []*struct {
-			Name string `json:"name"`
-		}{}
-		err = json.Unmarshal(responseContent, &teamResponse)
-		if err != nil {
-			log.Errorf("Cannot decode json: %s", string(responseContent))
-			return false, errors.Wrap(err)
-		}
-		log.Infof("Teams of %s: %v", token, teamResponse)
-		for _, team := range teamResponse {
-			if state.Proxy.HasTeam(team.Name) {
-				log.Infof("Found team: %s", team.Name)
-				hasTeam = true
-				break
-			}
-		}
-	}
-	return hasTeam, nil
+func (p *GithubProvider) getTeams(token string) ([]string, error) {
+	headers := map[string]string{
+		"Authorization": "token " + token,
+	}
+	statusCode, responseContent, err := utils.HTTPRequestJSON("GET", githubDefaultAPIURI+"/user/teams", "", headers)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode >= 300 {
+		log.Errorf("Invalid status code %d for token %s", statusCode, token)
+		return nil, errors.Errorf("invalid status code: %d", statusCode)
+	}
+	teamResponse := []*struct {
+		Name string `json:"name"`
+	}{}
+	err = json.Unmarshal(responseContent, &teamResponse)
+	if err != nil {
+		log.Errorf("Cannot decode json: %s", string(responseContent))
+		return nil, errors.Wrap(err)
+	}
+	log.Infof("Teams of %s: %v", token, teamResponse)
+	teams := []string{}
+	for _, team := range teamResponse {
+		teams = append(teams, team.Name)
+	}
+	return teams, nil
 }
@@ -1,16 +1,27 @@
 package provider
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"net/http"
 
 	"github.com/anduintransaction/oauth-proxy/proxy"
 )
 
 type Provider interface {
-	RedirectURI(proxy *proxy.Proxy, randomState string) string
+	RedirectURI(state *proxy.State) string
 	ErrorString(request *http.Request) string
 	RequestToken(state *proxy.State, code string) (string, error)
 	VerifyUser(state *proxy.State, token string) (*proxy.UserInfo, error)
+	GetUser(token string) (*proxy.UserInfo, error)
+	RevokeToken(proxy *proxy.Proxy, token string) error
+	CheckToken(proxy *proxy.Proxy, token string) error
+}
+
+// CodeChallenge derives the PKCE S256 challenge from a code verifier.
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
 }
 
 func GetProvider(name string) Provider {
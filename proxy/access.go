@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/anduintransaction/oauth-proxy/utils"
+	"gottb.io/goru/log"
+)
+
+type whilelist struct {
+	method string
+	path   *regexp.Regexp
+}
+
+// Access holds the authorization rules of a proxy or of one of its routes.
+type Access struct {
+	name          string
+	organizations utils.StringSet
+	teams         utils.StringSet
+	whitelists    []*whilelist
+}
+
+func newAccess(name string, organizations, teams, whitelists []string) (*Access, error) {
+	a := &Access{
+		name:          name,
+		organizations: utils.NewStringSet(organizations),
+		teams:         utils.NewStringSet(teams),
+		whitelists:    []*whilelist{},
+	}
+	var err error
+	for _, wl := range whitelists {
+		w := &whilelist{}
+		pieces := strings.SplitN(wl, ":", 2)
+		if len(pieces) == 1 {
+			w.method = "ANY"
+			w.path, err = regexp.Compile("^" + pieces[0] + "$")
+		} else {
+			w.method = strings.ToUpper(pieces[0])
+			w.path, err = regexp.Compile("^" + pieces[1] + "$")
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.whitelists = append(a.whitelists, w)
+	}
+	return a, nil
+}
+
+var referencedOrganizations = make(utils.StringSet)
+var referencedTeams = make(utils.StringSet)
+var adminAccess *Access
+
+func setupReferences() {
+	referencedOrganizations = make(utils.StringSet)
+	referencedTeams = make(utils.StringSet)
+	addReferences(Config.AdminOrganizations, Config.AdminTeams)
+	for _, p := range proxies {
+		addReferences(p.Organizations, p.Teams)
+		for _, route := range p.Routes {
+			addReferences(route.Organizations, route.Teams)
+		}
+	}
+}
+
+func addReferences(organizations, teams []string) {
+	for _, org := range organizations {
+		referencedOrganizations.Add(org)
+	}
+	for _, team := range teams {
+		referencedTeams.Add(team)
+	}
+}
+
+// AdminEnabled reports whether admin_organizations is configured.
+func AdminEnabled() bool {
+	return len(Config.AdminOrganizations) > 0
+}
+
+// IsAdmin reports whether user belongs to admin_organizations and, if configured, admin_teams.
+func IsAdmin(user *UserInfo) bool {
+	return AdminEnabled() && adminAccess != nil && adminAccess.Authorize(user)
+}
+
+// UsesTeams reports whether any proxy or route restricts access by team.
+func UsesTeams() bool {
+	return len(referencedTeams) > 0
+}
+
+// Referenced returns a copy of user keeping only the organizations and teams some rule refers to.
+func Referenced(user *UserInfo) *UserInfo {
+	referenced := *user
+	referenced.Organizations = []string{}
+	for _, org := range user.Organizations {
+		if referencedOrganizations.Has(org) {
+			referenced.Organizations = append(referenced.Organizations, org)
+		}
+	}
+	referenced.Teams = []string{}
+	for _, team := range user.Teams {
+		if referencedTeams.Has(team) {
+			referenced.Teams = append(referenced.Teams, team)
+		}
+	}
+	return &referenced
+}
+
+func (a *Access) HasOrg(org string) bool {
+	return a.organizations.Has(org)
+}
+
+func (a *Access) HasTeam(team string) bool {
+	return a.teams.Has(team)
+}
+
+func (a *Access) HasAnyOrg(orgs []string) bool {
+	for _, org := range orgs {
+		if a.HasOrg(org) {
+			log.Debugf("Found organization %s for %s", org, a.name)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Access) HasAnyTeam(teams []string) bool {
+	if len(a.teams) == 0 {
+		return true
+	}
+	for _, team := range teams {
+		if a.HasTeam(team) {
+			log.Debugf("Found team %s for %s", team, a.name)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Access) Authorize(user *UserInfo) bool {
+	return a.HasAnyOrg(user.Organizations) && a.HasAnyTeam(user.Teams)
+}
+
+func (a *Access) IsWhiteList(method, path string) bool {
+	for _, w := range a.whitelists {
+		if w.method != "ANY" && w.method != method {
+			continue
+		}
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+		matched := w.path.MatchString(path)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
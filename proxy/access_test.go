@@ -0,0 +1,38 @@
+package proxy
+
+import "testing"
+
+func TestIsAdmin(t *testing.T) {
+	defer func(orgs, teams []string) {
+		Config.AdminOrganizations, Config.AdminTeams = orgs, teams
+		adminAccess = nil
+	}(Config.AdminOrganizations, Config.AdminTeams)
+
+	admin := &UserInfo{Name: "admin", Organizations: []string{"your org"}, Teams: []string{"ops"}}
+	member := &UserInfo{Name: "member", Organizations: []string{"your org"}, Teams: []string{"dev"}}
+	outsider := &UserInfo{Name: "outsider", Organizations: []string{"other org"}, Teams: []string{"ops"}}
+	tests := []struct {
+		name  string
+		orgs  []string
+		teams []string
+		user  *UserInfo
+		admin bool
+	}{
+		{"disabled", nil, nil, admin, false},
+		{"org member", []string{"your org"}, nil, member, true},
+		{"other org", []string{"your org"}, nil, outsider, false},
+		{"team member", []string{"your org"}, []string{"ops"}, admin, true},
+		{"other team", []string{"your org"}, []string{"ops"}, member, false},
+	}
+	for _, test := range tests {
+		Config.AdminOrganizations, Config.AdminTeams = test.orgs, test.teams
+		var err error
+		adminAccess, err = newAccess("admin", test.orgs, test.teams, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if admin := IsAdmin(test.user); admin != test.admin {
+			t.Errorf("%s: IsAdmin(%s) = %v, want %v", test.name, test.user.Name, admin, test.admin)
+		}
+	}
+}
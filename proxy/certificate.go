@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"crypto/tls"
+
+	"gottb.io/goru/errors"
+)
+
+func (p *Proxy) setupCertificate() error {
+	if p.CertFile == "" && p.KeyFile == "" {
+		return nil
+	}
+	var err error
+	p.keyPair, err = LoadKeyPair(p.CertFile, p.KeyFile)
+	if err != nil {
+		return errors.Errorf("invalid certificate for %s: %s", p.RequestHost, err)
+	}
+	return nil
+}
+
+// Certificate returns the certificate configured for serverName, or nil.
+func Certificate(serverName string) *tls.Certificate {
+	p := GetProxy(serverName)
+	if p == nil || p.keyPair == nil {
+		return nil
+	}
+	return p.keyPair.Certificate()
+}
+
+// IsConfiguredHost reports whether host is the request_host of a proxy or the auth host.
+func IsConfiguredHost(host string) bool {
+	for requestHost := range proxyMap {
+		if SameHost(requestHost, host) {
+			return true
+		}
+	}
+	return authProxy != nil && SameHost(authProxy.RequestHost, host)
+}
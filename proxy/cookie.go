@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gottb.io/goru/errors"
+)
+
+func (p *Proxy) NewCookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Domain:   p.CookieDomain,
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   *p.CookieSecure,
+		HttpOnly: *p.CookieHTTPOnly,
+		SameSite: p.sameSite,
+	}
+}
+
+func (p *Proxy) CookieCovers(host string) bool {
+	return cookieDomainMatches(p.CookieDomain, host)
+}
+
+func (p *Proxy) setupCookie() error {
+	if p.CookieDomain == "" {
+		p.CookieDomain = Config.CookieDomain
+	}
+	if p.CookieDomain == "" {
+		p.CookieDomain = stripPort(p.RequestHost)
+	}
+	if !cookieDomainMatches(p.CookieDomain, p.RequestHost) {
+		return errors.Errorf("cookie domain %s does not cover request host %s", p.CookieDomain, p.RequestHost)
+	}
+	if p.CookieSecure == nil {
+		p.CookieSecure = Config.CookieSecure
+	}
+	if p.CookieSecure == nil {
+		secure := p.Scheme == "https"
+		p.CookieSecure = &secure
+	}
+	if p.CookieHTTPOnly == nil {
+		p.CookieHTTPOnly = Config.CookieHTTPOnly
+	}
+	if p.CookieHTTPOnly == nil {
+		httpOnly := true
+		p.CookieHTTPOnly = &httpOnly
+	}
+	if p.CookieSameSite == "" {
+		p.CookieSameSite = Config.CookieSameSite
+	}
+	switch strings.ToLower(p.CookieSameSite) {
+	case "", "lax":
+		p.sameSite = http.SameSiteLaxMode
+	case "strict":
+		p.sameSite = http.SameSiteStrictMode
+	case "none":
+		p.sameSite = http.SameSiteNoneMode
+	default:
+		return errors.Errorf("invalid cookie_same_site for %s: %s", p.RequestHost, p.CookieSameSite)
+	}
+	if p.Scheme == "https" && !*p.CookieSecure {
+		return errors.Errorf("cookie_secure must not be disabled for https host %s", p.RequestHost)
+	}
+	if p.sameSite == http.SameSiteNoneMode && !*p.CookieSecure {
+		return errors.Errorf("cookie_same_site none requires a secure cookie for %s", p.RequestHost)
+	}
+	return nil
+}
+
+func cookieDomainMatches(domain, host string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	host = strings.ToLower(stripPort(host))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func stripPort(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return h
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestSetupSSOCookie(t *testing.T) {
+	defer func(sso bool, domain, name string) {
+		Config.SSO, Config.CookieDomain, Config.CookieName = sso, domain, name
+	}(Config.SSO, Config.CookieDomain, Config.CookieName)
+	Config.SSO = true
+	Config.CookieDomain = "your.server"
+	Config.CookieName = "oauth-proxy"
+
+	tests := []struct {
+		name         string
+		proxy        *Proxy
+		cookieDomain string
+		fail         bool
+	}{
+		{"shared domain", &Proxy{RequestHost: "www.your.server"}, "your.server", false},
+		{"shared domain with port", &Proxy{RequestHost: "WWW.your.server:8080"}, "your.server", false},
+		{"other domain", &Proxy{RequestHost: "www.other.server:8080"}, "www.other.server", false},
+		{"domain override", &Proxy{RequestHost: "www.your.server", CookieDomain: "www.your.server"}, "", true},
+		{"domain override on other domain", &Proxy{RequestHost: "www.other.server", CookieDomain: "other.server"}, "", true},
+		{"same cookie name", &Proxy{RequestHost: "www.your.server", CookieName: "oauth-proxy"}, "your.server", false},
+		{"name override", &Proxy{RequestHost: "www.your.server", CookieName: "admin"}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.proxy.Scheme = "http"
+			test.proxy.EndPoint = "http://127.0.0.1:1"
+			err := test.proxy.setup()
+			if test.fail {
+				if err == nil {
+					t.Fatal("setup succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer test.proxy.close()
+			if test.proxy.CookieDomain != test.cookieDomain {
+				t.Errorf("cookie domain = %q, want %q", test.proxy.CookieDomain, test.cookieDomain)
+			}
+		})
+	}
+}
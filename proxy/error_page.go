@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anduintransaction/oauth-proxy/views"
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const (
+	requestIDHeader    = "X-Request-Id"
+	maxRequestIDLength = 128
+)
+
+// ErrorPage is the data given to the error page templates of a proxy.
+type ErrorPage struct {
+	Host       string
+	Status     int
+	RequestID  string
+	RetryAfter int
+}
+
+func (p *Proxy) setupErrorPages() error {
+	p.errorPages = make(map[int]*template.Template)
+	files := map[int]string{
+		http.StatusBadGateway:         p.ErrorPage502,
+		http.StatusServiceUnavailable: p.ErrorPage503,
+		http.StatusGatewayTimeout:     p.ErrorPage504,
+	}
+	for status, file := range files {
+		if file == "" {
+			continue
+		}
+		t, err := template.ParseFiles(file)
+		if err != nil {
+			return errors.Errorf("invalid error page for %s: %s", p.RequestHost, err)
+		}
+		p.errorPages[status] = t
+	}
+	return nil
+}
+
+// RenderError writes the error page of status for a request no upstream could serve.
+func (p *Proxy) RenderError(w http.ResponseWriter, r *http.Request, status int) {
+	page := &ErrorPage{
+		Host:       p.RequestHost,
+		Status:     status,
+		RequestID:  RequestID(r),
+		RetryAfter: p.retryAfter(r.URL.Path),
+	}
+	var content []byte
+	var err error
+	t := p.errorPages[status]
+	if t != nil {
+		buffer := &bytes.Buffer{}
+		err = t.Execute(buffer, page)
+		content = buffer.Bytes()
+	} else {
+		content, err = views.UpstreamError.Render(page.Host, page.Status, page.RequestID, page.RetryAfter)
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	header.Set(requestIDHeader, page.RequestID)
+	if status == http.StatusServiceUnavailable {
+		header.Set("Retry-After", strconv.Itoa(page.RetryAfter))
+	}
+	p.rewriteResponseHeaders(r, header)
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+func (p *Proxy) retryAfter(path string) int {
+	pool := p.upstreamPool(path)
+	if pool == nil {
+		return defaultFailTimeout
+	}
+	return int(pool.failTimeout / time.Second)
+}
+
+// RequestID returns the X-Request-Id of r, replacing a missing or invalid one with a new ID.
+func RequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if isValidRequestID(id) {
+		return id
+	}
+	id = newRequestID()
+	r.Header.Set(requestIDHeader, id)
+	return id
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Error(err)
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func errorStatus(err error) int {
+	var netErr net.Error
+	if stderrors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
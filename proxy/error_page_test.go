@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		kept bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"A.b_C-9", true},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+		{"id with spaces", false},
+		{"<script>alert(1)</script>", false},
+		{"id\r\nX-Injected: 1", false},
+		{"id\"quoted", false},
+		{"idé", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://www.your.server/", nil)
+		r.Header[requestIDHeader] = []string{test.id}
+		id := RequestID(r)
+		if (id == test.id) != test.kept {
+			t.Errorf("RequestID(%q) = %q", test.id, id)
+		}
+		if !isValidRequestID(id) {
+			t.Errorf("RequestID(%q) returned invalid ID %q", test.id, id)
+		}
+		if r.Header.Get(requestIDHeader) != id {
+			t.Errorf("RequestID(%q) set header %q, want %q", test.id, r.Header.Get(requestIDHeader), id)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"gottb.io/goru/errors"
+)
+
+const (
+	headerSet    = "set"
+	headerAppend = "append"
+	headerRemove = "remove"
+)
+
+// HeaderRule sets, appends or removes a header of upstream requests or responses.
+type HeaderRule struct {
+	Action string `config:"action"`
+	Name   string `config:"name"`
+	Value  string `config:"value"`
+}
+
+var headerVariables = map[string]bool{
+	"user":          true,
+	"email":         true,
+	"organizations": true,
+	"teams":         true,
+	"host":          true,
+	"request_id":    true,
+	"$":             true,
+}
+
+func (p *Proxy) setupHeaderRules() error {
+	rules := append(append([]*HeaderRule{}, p.RequestHeaders...), p.ResponseHeaders...)
+	for _, rule := range rules {
+		switch strings.ToLower(rule.Action) {
+		case headerSet, headerAppend, headerRemove:
+		default:
+			return errors.Errorf("invalid header action for %s: %q", p.RequestHost, rule.Action)
+		}
+		if rule.Name == "" {
+			return errors.Errorf("header name must be configured for %s", p.RequestHost)
+		}
+		var unknown string
+		os.Expand(rule.Value, func(name string) string {
+			if !headerVariables[name] && unknown == "" {
+				unknown = name
+			}
+			return ""
+		})
+		if unknown != "" {
+			return errors.Errorf("unknown variable in header %s of %s: %s", rule.Name, p.RequestHost, unknown)
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) rewriteRequestHeaders(req *http.Request) {
+	if len(p.RequestHeaders) == 0 {
+		return
+	}
+	c := requestUpstreamContext(req)
+	applyHeaderRules(p.RequestHeaders, req.Header, p.headerVariables(req, c))
+	host, ok := req.Header["Host"]
+	if ok {
+		req.Host = strings.Join(host, ",")
+		req.Header.Del("Host")
+	}
+}
+
+func (p *Proxy) rewriteResponseHeaders(req *http.Request, header http.Header) {
+	if len(p.ResponseHeaders) == 0 {
+		return
+	}
+	c := requestUpstreamContext(req)
+	applyHeaderRules(p.ResponseHeaders, header, p.headerVariables(req, c))
+}
+
+func (p *Proxy) headerVariables(req *http.Request, c *upstreamContext) func(string) string {
+	var user *UserInfo
+	if c != nil {
+		user = c.user
+	}
+	return func(name string) string {
+		switch name {
+		case "$":
+			return "$"
+		case "host":
+			return p.RequestHost
+		case "request_id":
+			return req.Header.Get(requestIDHeader)
+		}
+		if user == nil {
+			return ""
+		}
+		switch name {
+		case "user":
+			return user.Name
+		case "email":
+			return user.Email
+		case "organizations":
+			return strings.Join(user.Organizations, ",")
+		case "teams":
+			return strings.Join(user.Teams, ",")
+		}
+		return ""
+	}
+}
+
+func applyHeaderRules(rules []*HeaderRule, header http.Header, variables func(string) string) {
+	for _, rule := range rules {
+		switch strings.ToLower(rule.Action) {
+		case headerSet:
+			header.Set(rule.Name, os.Expand(rule.Value, variables))
+		case headerAppend:
+			header.Add(rule.Name, os.Expand(rule.Value, variables))
+		case headerRemove:
+			header.Del(rule.Name)
+		}
+	}
+}
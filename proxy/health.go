@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"gottb.io/goru/log"
+)
+
+const (
+	defaultHealthCheckInterval = 10
+	defaultHealthCheckStatus   = http.StatusOK
+)
+
+type UpstreamStatus struct {
+	Route             string `json:"route,omitempty"`
+	EndPoint          string `json:"end_point"`
+	Healthy           bool   `json:"healthy"`
+	Ejected           bool   `json:"ejected"`
+	ActiveConnections int64  `json:"active_connections"`
+}
+
+func (p *Proxy) UpstreamStatus() []*UpstreamStatus {
+	statuses := upstreamStatus("", p.upstreams)
+	for _, route := range p.Routes {
+		statuses = append(statuses, upstreamStatus(route.Path, route.upstreams)...)
+	}
+	return statuses
+}
+
+func upstreamStatus(route string, pool *upstreamPool) []*UpstreamStatus {
+	statuses := []*UpstreamStatus{}
+	if pool == nil {
+		return statuses
+	}
+	now := time.Now()
+	for _, u := range pool.upstreams {
+		u.mutex.Lock()
+		statuses = append(statuses, &UpstreamStatus{
+			Route:             route,
+			EndPoint:          u.target.String(),
+			Healthy:           !u.unhealthy,
+			Ejected:           now.Before(u.ejectedUntil),
+			ActiveConnections: atomic.LoadInt64(&u.active),
+		})
+		u.mutex.Unlock()
+	}
+	return statuses
+}
+
+// Available reports whether an upstream serving path can take requests.
+func (p *Proxy) Available(path string) bool {
+	pool := p.upstreamPool(path)
+	if pool == nil {
+		return true
+	}
+	return pool.available()
+}
+
+type healthChecker struct {
+	proxy  *Proxy
+	client *http.Client
+	ticker *time.Ticker
+	stop   chan bool
+}
+
+func (p *Proxy) startHealthCheck() {
+	if p.HealthCheckPath == "" {
+		return
+	}
+	if p.HealthCheckInterval <= 0 {
+		p.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if p.HealthCheckStatus == 0 {
+		p.HealthCheckStatus = defaultHealthCheckStatus
+	}
+	interval := time.Duration(p.HealthCheckInterval) * time.Second
+	c := &healthChecker{
+		proxy: p,
+		client: &http.Client{
+			Transport: p.reverseProxy.Transport,
+			Timeout:   interval,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ticker: time.NewTicker(interval),
+		stop:   make(chan bool, 1),
+	}
+	p.healthChecker = c
+	go c.run()
+}
+
+func (p *Proxy) stopHealthCheck() {
+	if p.healthChecker != nil {
+		p.healthChecker.quit()
+	}
+}
+
+func (p *Proxy) close() {
+	p.stopHealthCheck()
+	if p.reverseProxy == nil {
+		return
+	}
+	transport := p.reverseProxy.Transport
+	if retry, ok := transport.(*retryTransport); ok {
+		transport = retry.transport
+	}
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+func (c *healthChecker) quit() {
+	c.ticker.Stop()
+	c.stop <- true
+}
+
+func (c *healthChecker) run() {
+	c.checkAll()
+	for {
+		select {
+		case <-c.stop:
+			log.Debugf("Health check of %s stopped", c.proxy.RequestHost)
+			return
+		case <-c.ticker.C:
+			c.checkAll()
+		}
+	}
+}
+
+func (c *healthChecker) checkAll() {
+	for _, pool := range c.proxy.upstreamPools() {
+		for _, u := range pool.upstreams {
+			healthy := c.check(u)
+			u.mutex.Lock()
+			if u.unhealthy == healthy {
+				if healthy {
+					log.Infof("Upstream %s of %s is healthy again", u.target.String(), c.proxy.RequestHost)
+				} else {
+					log.Warnf("Upstream %s of %s is unhealthy", u.target.String(), c.proxy.RequestHost)
+				}
+			}
+			u.unhealthy = !healthy
+			u.mutex.Unlock()
+		}
+	}
+}
+
+func (c *healthChecker) check(u *upstream) bool {
+	checkURL := *u.target
+	checkURL.Path = c.proxy.singleJoiningSlash(u.target.Path, c.proxy.HealthCheckPath)
+	resp, err := c.client.Get(checkURL.String())
+	if err != nil {
+		log.Debugf("Health check %s failed: %s", checkURL.String(), err)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != c.proxy.HealthCheckStatus {
+		log.Debugf("Health check %s returned %d, expect %d", checkURL.String(), resp.StatusCode, c.proxy.HealthCheckStatus)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"container/list"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const maxDerivedProxies = 256
+
+type hostPattern struct {
+	regex    *regexp.Regexp
+	template *Proxy
+}
+
+var hostPatterns []*hostPattern
+var derivedProxies map[string]*list.Element
+var derivedList *list.List
+var derivedMutex sync.Mutex
+
+func newHostPattern(p *Proxy) (*hostPattern, error) {
+	var expr string
+	switch {
+	case strings.HasPrefix(p.RequestHost, "~"):
+		expr = "^(?:" + strings.TrimPrefix(p.RequestHost, "~") + ")$"
+	case strings.Contains(p.RequestHost, "*"):
+		pieces := strings.Split(p.RequestHost, "*")
+		for i, piece := range pieces {
+			pieces[i] = regexp.QuoteMeta(piece)
+		}
+		expr = "^" + strings.Join(pieces, "([^.]+)") + "$"
+	default:
+		return nil, nil
+	}
+	regex, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return nil, errors.Errorf("invalid request_host pattern %s: %s", p.RequestHost, err)
+	}
+	return &hostPattern{regex: regex, template: p}, nil
+}
+
+func (h *hostPattern) derive(requestHost string) (*Proxy, bool, error) {
+	host := stripPort(requestHost)
+	match := h.regex.FindStringSubmatchIndex(host)
+	if match == nil {
+		return nil, false, nil
+	}
+	expand := func(value string) string {
+		return string(h.regex.ExpandString(nil, value, host, match))
+	}
+	p := *h.template
+	p.RequestHost = requestHost
+	p.EndPoint = expand(p.EndPoint)
+	p.EndPoints = make([]string, len(h.template.EndPoints))
+	for i, endPoint := range h.template.EndPoints {
+		p.EndPoints[i] = expand(endPoint)
+	}
+	p.Routes = make([]*Route, len(h.template.Routes))
+	for i, route := range h.template.Routes {
+		r := *route
+		r.EndPoint = expand(r.EndPoint)
+		r.EndPoints = make([]string, len(route.EndPoints))
+		for j, endPoint := range route.EndPoints {
+			r.EndPoints[j] = expand(endPoint)
+		}
+		p.Routes[i] = &r
+	}
+	err := p.setup()
+	if err != nil {
+		return nil, true, err
+	}
+	return &p, true, nil
+}
+
+// IsProxiedHost reports whether host is served by a proxy, a request_host pattern or the auth host.
+func IsProxiedHost(host string) bool {
+	if IsConfiguredHost(host) {
+		return true
+	}
+	for _, pattern := range hostPatterns {
+		if pattern.regex.MatchString(stripPort(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func getDerivedProxy(requestHost string) *Proxy {
+	if len(hostPatterns) == 0 {
+		return nil
+	}
+	host := strings.ToLower(stripPort(requestHost))
+	derivedMutex.Lock()
+	defer derivedMutex.Unlock()
+	element, ok := derivedProxies[host]
+	if ok {
+		derivedList.MoveToFront(element)
+		return element.Value.(*Proxy)
+	}
+	for _, pattern := range hostPatterns {
+		p, matched, err := pattern.derive(host)
+		if !matched {
+			continue
+		}
+		if err != nil {
+			log.Errorf("Cannot create proxy for %s from %s: %s", host, pattern.template.RequestHost, err)
+			return nil
+		}
+		if derivedList.Len() >= maxDerivedProxies {
+			evicted := derivedList.Remove(derivedList.Back()).(*Proxy)
+			delete(derivedProxies, evicted.RequestHost)
+			evicted.close()
+			log.Debugf("Proxy for %s evicted", evicted.RequestHost)
+		}
+		log.Debugf("Proxy created for %s from %s", host, pattern.template.RequestHost)
+		derivedProxies[host] = derivedList.PushFront(p)
+		return p
+	}
+	return nil
+}
+
+func clearDerivedProxies() {
+	derivedMutex.Lock()
+	defer derivedMutex.Unlock()
+	for _, element := range derivedProxies {
+		element.Value.(*Proxy).close()
+	}
+	derivedProxies = make(map[string]*list.Element)
+	derivedList = list.New()
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetDerivedProxy(t *testing.T) {
+	template := &Proxy{
+		Scheme:      "http",
+		RequestHost: "*.apps.your.server",
+		EndPoint:    "http://$1.internal:8080",
+	}
+	pattern, err := newHostPattern(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPatterns = []*hostPattern{pattern}
+	clearDerivedProxies()
+	defer func() {
+		hostPatterns = nil
+		clearDerivedProxies()
+	}()
+
+	first := getDerivedProxy("Wiki.Apps.Your.Server:8443")
+	if first == nil {
+		t.Fatal("no proxy derived for Wiki.Apps.Your.Server:8443")
+	}
+	if first.RequestHost != "wiki.apps.your.server" || first.EndPoint != "http://wiki.internal:8080" {
+		t.Fatalf("derived proxy for %s to %s", first.RequestHost, first.EndPoint)
+	}
+	if p := getDerivedProxy("wiki.apps.your.server"); p != first {
+		t.Fatal("derived proxy is not cached by lowercased host without port")
+	}
+	if p := getDerivedProxy("your.server"); p != nil {
+		t.Fatalf("proxy derived for your.server: %s", p.RequestHost)
+	}
+
+	for i := 0; i < maxDerivedProxies; i++ {
+		getDerivedProxy(fmt.Sprintf("app%d.apps.your.server", i))
+		getDerivedProxy("wiki.apps.your.server")
+	}
+	if derivedList.Len() != maxDerivedProxies {
+		t.Fatalf("%d derived proxies cached, want %d", derivedList.Len(), maxDerivedProxies)
+	}
+	if p := getDerivedProxy("wiki.apps.your.server"); p != first {
+		t.Fatal("recently used proxy was evicted")
+	}
+	if _, ok := derivedProxies["app0.apps.your.server"]; ok {
+		t.Fatal("least recently used proxy was not evicted")
+	}
+	if _, ok := derivedProxies["app1.apps.your.server"]; !ok {
+		t.Fatal("more than one proxy was evicted")
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"www.your.server", "www.your.server", true},
+		{"www.your.server", "WWW.Your.Server", true},
+		{"www.your.server", "www.your.server:8443", true},
+		{"WWW.your.server:80", "www.your.server", true},
+		{"www.your.server", "api.your.server", false},
+		{"www.your.server", "www.your.server.evil", false},
+	}
+	for _, test := range tests {
+		if same := SameHost(test.a, test.b); same != test.same {
+			t.Errorf("SameHost(%q, %q) = %v, want %v", test.a, test.b, same, test.same)
+		}
+	}
+}
+
+func TestIsProxiedHost(t *testing.T) {
+	pattern, err := newHostPattern(&Proxy{
+		Scheme:      "http",
+		RequestHost: "*.apps.your.server",
+		EndPoint:    "http://$1.internal:8080",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyMap = map[string]*Proxy{"www.your.server": {RequestHost: "www.your.server"}}
+	hostPatterns = []*hostPattern{pattern}
+	clearDerivedProxies()
+	defer func() {
+		proxyMap = nil
+		hostPatterns = nil
+	}()
+
+	tests := []struct {
+		host    string
+		proxied bool
+	}{
+		{"www.your.server", true},
+		{"WWW.your.server:8443", true},
+		{"wiki.apps.your.server", true},
+		{"Wiki.Apps.Your.Server:8443", true},
+		{"apps.your.server", false},
+		{"evil.com", false},
+	}
+	for _, test := range tests {
+		if proxied := IsProxiedHost(test.host); proxied != test.proxied {
+			t.Errorf("IsProxiedHost(%q) = %v, want %v", test.host, proxied, test.proxied)
+		}
+	}
+	if derivedList.Len() != 0 {
+		t.Fatalf("%d proxies derived while checking hosts", derivedList.Len())
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+// KeyPair is a certificate loaded from PEM files and reloaded when they change.
+type KeyPair struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+var keyPairs = make(map[string]*KeyPair)
+var keyPairsMutex sync.Mutex
+
+// LoadKeyPair returns the shared KeyPair of the given files.
+func LoadKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	keyPairsMutex.Lock()
+	defer keyPairsMutex.Unlock()
+	key := certFile + "\x00" + keyFile
+	k := keyPairs[key]
+	if k != nil {
+		return k, nil
+	}
+	k, err := newKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPairs[key] = k
+	return k, nil
+}
+
+func newKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.Errorf("both a certificate file and a key file must be configured")
+	}
+	k := &KeyPair{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := k.reload()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyPair) reload() error {
+	certInfo, err := os.Stat(k.certFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	keyInfo, err := os.Stat(k.keyFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.cert != nil && certInfo.ModTime().Equal(k.certModTime) && keyInfo.ModTime().Equal(k.keyModTime) {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if k.cert != nil {
+		log.Infof("Reloaded certificate %s", k.certFile)
+	}
+	k.cert = &certificate
+	k.certModTime = certInfo.ModTime()
+	k.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// Certificate returns the last certificate that loaded successfully.
+func (k *KeyPair) Certificate() *tls.Certificate {
+	err := k.reload()
+	if err != nil {
+		log.Errorf("Could not reload certificate %s: %s", k.certFile, err)
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.cert
+}
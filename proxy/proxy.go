@@ -1,7 +1,7 @@
 package proxy
 
 import (
-	"crypto/tls"
+	"html/template"
 	"math/rand"
 	"net/http"
 	"net/http/httputil"
@@ -9,81 +9,178 @@ import (
 	"strings"
 	"time"
 
-	"regexp"
-
-	"github.com/anduintransaction/oauth-proxy/utils"
 	"gottb.io/goru/config"
+	"gottb.io/goru/errors"
 	"gottb.io/goru/log"
 )
 
-type whilelist struct {
-	method string
-	path   *regexp.Regexp
-}
-
 type Proxy struct {
-	Provider      string   `config:"provider"`
-	Scheme        string   `config:"scheme"`
-	RedirectURI   string   `config:"redirect_uri"`
-	RequestHost   string   `config:"request_host"`
-	EndPoint      string   `config:"end_point"`
-	PreserveHost  bool     `config:"preserve_host"`
-	ClientID      string   `config:"client_id"`
-	ClientSecret  string   `config:"client_secret"`
-	CallbackURI   string   `config:"callback_uri"`
-	Organizations []string `config:"organizations"`
-	Teams         []string `config:"teams"`
-	Whitelists    []string `config:"whitelists"`
-	organizations utils.StringSet
-	teams         utils.StringSet
-	target        *url.URL
-	whitelists    []*whilelist
+	Provider    string   `config:"provider"`
+	Scheme      string   `config:"scheme"`
+	RedirectURI string   `config:"redirect_uri"`
+	RequestHost string   `config:"request_host"`
+	EndPoint    string   `config:"end_point"`
+	EndPoints   []string `config:"end_points"`
+	Balance     string   `config:"balance"`
+	MaxFails    int      `config:"max_fails"`
+	FailTimeout int      `config:"fail_timeout"`
+
+	FlushInterval    int    `config:"flush_interval"`
+	IdleTimeout      int    `config:"idle_timeout"`
+	Protocol         string `config:"protocol"`
+	AllowBearerToken bool   `config:"allow_bearer_token"`
+
+	DialTimeout           int  `config:"dial_timeout"`
+	ResponseHeaderTimeout int  `config:"response_header_timeout"`
+	RequestTimeout        int  `config:"request_timeout"`
+	Retries               int  `config:"retries"`
+	CircuitBreaker        bool `config:"circuit_breaker"`
+
+	ErrorPage502 string `config:"error_page_502"`
+	ErrorPage503 string `config:"error_page_503"`
+	ErrorPage504 string `config:"error_page_504"`
+
+	RequestHeaders  []*HeaderRule `config:"request_headers"`
+	ResponseHeaders []*HeaderRule `config:"response_headers"`
+
+	RewriteLocation     *bool `config:"rewrite_location"`
+	RewriteCookieDomain *bool `config:"rewrite_cookie_domain"`
+
+	TLSCAFile          string `config:"tls_ca_file"`
+	TLSServerName      string `config:"tls_server_name"`
+	InsecureSkipVerify bool   `config:"insecure_skip_verify"`
+	TLSClientCertFile  string `config:"tls_client_cert_file"`
+	TLSClientKeyFile   string `config:"tls_client_key_file"`
+
+	CertFile string `config:"cert_file"`
+	KeyFile  string `config:"key_file"`
+
+	PreserveHost    bool     `config:"preserve_host"`
+	ClientID        string   `config:"client_id"`
+	ClientSecret    string   `config:"client_secret"`
+	CallbackURI     string   `config:"callback_uri"`
+	Scopes          []string `config:"scopes"`
+	RedirectDomains []string `config:"redirect_domains"`
+	Organizations   []string `config:"organizations"`
+	Teams           []string `config:"teams"`
+	Whitelists      []string `config:"whitelists"`
+	Routes          []*Route `config:"routes"`
+
+	HealthCheckPath     string `config:"health_check_path"`
+	HealthCheckInterval int    `config:"health_check_interval"`
+	HealthCheckStatus   int    `config:"health_check_status"`
+
+	StateTimeout       int    `config:"state_timeout"`
+	CookieTimeout      int    `config:"cookie_timeout"`
+	CookieName         string `config:"cookie_name"`
+	SessionMaxLifetime *int   `config:"session_max_lifetime"`
+	SessionIdleTimeout *int   `config:"session_idle_timeout"`
+
+	CookieDomain   string `config:"cookie_domain"`
+	CookieSecure   *bool  `config:"cookie_secure"`
+	CookieHTTPOnly *bool  `config:"cookie_http_only"`
+	CookieSameSite string `config:"cookie_same_site"`
+
+	*Access
+	upstreams     *upstreamPool
 	reverseProxy  *httputil.ReverseProxy
+	healthChecker *healthChecker
+	keyPair       *KeyPair
+	errorPages    map[int]*template.Template
+	sameSite      http.SameSite
 }
 
-func (p *Proxy) HasOrg(org string) bool {
-	return p.organizations.Has(org)
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.serveUpstream(w, r, nil)
 }
 
-func (p *Proxy) HasTeam(team string) bool {
-	return p.teams.Has(team)
+// Serve proxies r on behalf of user, which is nil for whitelisted requests.
+func (p *Proxy) Serve(w http.ResponseWriter, r *http.Request, user *UserInfo) {
+	p.serveUpstream(w, r, user)
 }
 
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.reverseProxy.ServeHTTP(w, r)
+func (p *Proxy) setupOAuth() {
+	if p.Provider == "" {
+		p.Provider = Config.Provider
+	}
+	if p.ClientID == "" {
+		p.ClientID = Config.ClientID
+	}
+	if p.ClientSecret == "" {
+		p.ClientSecret = Config.ClientSecret
+	}
+	if p.CallbackURI == "" {
+		p.CallbackURI = Config.CallbackURI
+	}
+	if strings.HasPrefix(p.CallbackURI, "/") {
+		p.CallbackURI = p.Scheme + "://" + p.RequestHost + p.CallbackURI
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = Config.Scopes
+	}
+	if len(p.RedirectDomains) == 0 {
+		p.RedirectDomains = Config.RedirectDomains
+	}
+	if p.StateTimeout == 0 {
+		p.StateTimeout = Config.StateTimeout
+	}
+	if p.CookieTimeout == 0 {
+		p.CookieTimeout = Config.CookieTimeout
+	}
+	if p.CookieName == "" {
+		p.CookieName = Config.CookieName
+	}
+	if p.SessionMaxLifetime == nil {
+		p.SessionMaxLifetime = Config.SessionMaxLifetime
+	}
+	if p.SessionMaxLifetime == nil {
+		p.SessionMaxLifetime = new(int)
+	}
+	if p.SessionIdleTimeout == nil {
+		p.SessionIdleTimeout = Config.SessionIdleTimeout
+	}
+	if p.SessionIdleTimeout == nil {
+		p.SessionIdleTimeout = new(int)
+	}
 }
 
-func (p *Proxy) IsWhiteList(method, path string) bool {
-	for _, w := range p.whitelists {
-		if w.method != "ANY" && w.method != method {
-			continue
-		}
-		path = strings.TrimRight(path, "/")
-		if path == "" {
-			path = "/"
-		}
-		matched := w.path.MatchString(path)
-		if matched {
-			return true
+func (p *Proxy) createReverseProxy() error {
+	transport, err := p.createTransport()
+	if err != nil {
+		return errors.Errorf("invalid upstream transport for %s: %s", p.RequestHost, err)
+	}
+	var roundTripper http.RoundTripper = transport
+	if p.Retries > 0 {
+		roundTripper = &retryTransport{
+			proxy:     p,
+			transport: transport,
 		}
 	}
-	return false
-}
-
-func (p *Proxy) createReverseProxy() {
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	p.reverseProxy = &httputil.ReverseProxy{
-		Director:  p.transformRequest,
-		Transport: transport,
+		Director:       p.direct,
+		Transport:      roundTripper,
+		ModifyResponse: p.handleResponse,
+		ErrorHandler:   p.handleError,
+		FlushInterval:  time.Duration(p.FlushInterval) * time.Millisecond,
 	}
+	return nil
+}
+
+func (p *Proxy) direct(req *http.Request) {
+	p.transformRequest(req)
+	p.rewriteRequestHeaders(req)
 }
 
 func (p *Proxy) transformRequest(req *http.Request) {
-	req.URL.Scheme = p.target.Scheme
-	req.URL.Host = p.target.Host
-	req.URL.Path = p.singleJoiningSlash(p.target.Path, req.URL.Path)
-	targetQuery := p.target.RawQuery
+	c := requestUpstreamContext(req)
+	if c.route != nil && c.route.StripPrefix {
+		c.route.stripPrefix(req)
+	}
+	target := c.upstream.target
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = p.singleJoiningSlash(target.Path, req.URL.Path)
+	targetQuery := target.RawQuery
 	if targetQuery == "" || req.URL.RawQuery == "" {
 		req.URL.RawQuery = targetQuery + req.URL.RawQuery
 	} else {
@@ -94,8 +191,8 @@ func (p *Proxy) transformRequest(req *http.Request) {
 		req.Header.Set("User-Agent", "")
 	}
 	if !p.PreserveHost {
-		req.Header.Set("Host", p.target.Host)
-		req.Host = p.target.Host
+		req.Header.Set("Host", target.Host)
+		req.Host = target.Host
 	}
 }
 
@@ -112,19 +209,40 @@ func (p *Proxy) singleJoiningSlash(a, b string) string {
 }
 
 var Config struct {
-	Provider      string `config:"provider"`
-	ClientID      string `config:"client_id"`
-	ClientSecret  string `config:"client_secret"`
-	CallbackURI   string `config:"callback_uri"`
-	StateTimeout  int    `config:"state_timeout"`
-	CookieTimeout int    `config:"cookie_timeout"`
-	CookieName    string `config:"cookie_name"`
-	CheckVersion  bool   `config:"check_version"`
-	Version       int64
+	Provider        string   `config:"provider"`
+	ClientID        string   `config:"client_id"`
+	ClientSecret    string   `config:"client_secret"`
+	CallbackURI     string   `config:"callback_uri"`
+	Scopes          []string `config:"scopes"`
+	RedirectDomains []string `config:"redirect_domains"`
+	StateTimeout    int      `config:"state_timeout"`
+	CookieTimeout   int      `config:"cookie_timeout"`
+	CookieName      string   `config:"cookie_name"`
+
+	SessionMaxLifetime *int `config:"session_max_lifetime"`
+	SessionIdleTimeout *int `config:"session_idle_timeout"`
+
+	CheckVersion bool   `config:"check_version"`
+	SSO          bool   `config:"sso"`
+	AuthURL      string `config:"auth_url"`
+
+	SignOutURL       string   `config:"sign_out_url"`
+	SignOutRedirects []string `config:"sign_out_redirects"`
+
+	AdminOrganizations []string `config:"admin_organizations"`
+	AdminTeams         []string `config:"admin_teams"`
+
+	CookieDomain   string `config:"cookie_domain"`
+	CookieSecure   *bool  `config:"cookie_secure"`
+	CookieHTTPOnly *bool  `config:"cookie_http_only"`
+	CookieSameSite string `config:"cookie_same_site"`
+
+	Version int64
 }
 
 var proxies []*Proxy
 var proxyMap map[string]*Proxy
+var authProxy *Proxy
 
 func Start(config *config.Config) error {
 	oauthConfig, err := config.Get("oauth")
@@ -144,58 +262,181 @@ func Start(config *config.Config) error {
 	if err != nil {
 		return err
 	}
+	setupReferences()
+	adminAccess, err = newAccess("admin", Config.AdminOrganizations, Config.AdminTeams, nil)
+	if err != nil {
+		return err
+	}
+	if Config.SSO && Config.CookieDomain == "" {
+		return errors.Errorf("cookie_domain must be configured in sso mode")
+	}
 	proxyMap = make(map[string]*Proxy)
+	hostPatterns = []*hostPattern{}
+	clearDerivedProxies()
 	for _, proxy := range proxies {
-		if proxy.Provider == "" {
-			proxy.Provider = Config.Provider
-		}
-		if proxy.ClientID == "" {
-			proxy.ClientID = Config.ClientID
-		}
-		if proxy.ClientSecret == "" {
-			proxy.ClientSecret = Config.ClientSecret
+		pattern, err := newHostPattern(proxy)
+		if err != nil {
+			return err
 		}
-		if proxy.CallbackURI == "" {
-			proxy.CallbackURI = Config.CallbackURI
+		if pattern != nil {
+			hostPatterns = append(hostPatterns, pattern)
+			continue
 		}
-		proxy.organizations = utils.NewStringSet(proxy.Organizations)
-		proxy.teams = utils.NewStringSet(proxy.Teams)
-		proxy.target, err = url.Parse(proxy.EndPoint)
+		err = proxy.setup()
 		if err != nil {
 			return err
 		}
-		proxy.whitelists = []*whilelist{}
-		for _, wl := range proxy.Whitelists {
-			w := &whilelist{}
-			pieces := strings.SplitN(wl, ":", 2)
-			if len(pieces) == 1 {
-				w.method = "ANY"
-				w.path, err = regexp.Compile("^" + pieces[0] + "$")
-			} else {
-				w.method = strings.ToUpper(pieces[0])
-				w.path, err = regexp.Compile("^" + pieces[1] + "$")
-			}
-			if err != nil {
-				return err
-			}
-			proxy.whitelists = append(proxy.whitelists, w)
-		}
-		proxy.createReverseProxy()
-		proxyMap[proxy.RequestHost] = proxy
+		proxyMap[strings.ToLower(proxy.RequestHost)] = proxy
 		log.Debug(proxy)
 	}
 
+	err = setupAuthProxy()
+	if err != nil {
+		return err
+	}
+
+	if Config.SignOutURL == "" {
+		Config.SignOutURL = "/"
+	}
+
 	rand.Seed(time.Now().UnixNano())
 	Config.Version = rand.Int63()
-	defaultStateMap = newStateMap(Config.StateTimeout)
+	gcInterval := Config.StateTimeout
+	for _, proxy := range proxies {
+		if proxy.StateTimeout > gcInterval {
+			gcInterval = proxy.StateTimeout
+		}
+	}
+	defaultStateMap = newStateMap(gcInterval)
+	return nil
+}
+
+func (p *Proxy) setup() error {
+	if Config.SSO && p.CookieName != "" && p.CookieName != Config.CookieName {
+		return errors.Errorf("cookie_name of %s must not be overridden in sso mode", p.RequestHost)
+	}
+	p.setupOAuth()
+	if Config.SSO && p.CookieDomain != "" && p.CookieDomain != Config.CookieDomain {
+		return errors.Errorf("cookie_domain of %s must not be overridden in sso mode", p.RequestHost)
+	}
+	if Config.SSO && !cookieDomainMatches(Config.CookieDomain, p.RequestHost) {
+		p.CookieDomain = stripPort(p.RequestHost)
+	}
+	err := p.setupCookie()
+	if err != nil {
+		return err
+	}
+	p.Access, err = newAccess(p.RequestHost, p.Organizations, p.Teams, p.Whitelists)
+	if err != nil {
+		return err
+	}
+	if len(p.endPoints()) > 0 || len(p.Routes) == 0 {
+		p.upstreams, err = newUpstreamPool(p.endPoints(), p.Balance, p.MaxFails, p.FailTimeout, p.CircuitBreaker)
+		if err != nil {
+			return errors.Errorf("invalid upstream for %s: %s", p.RequestHost, err)
+		}
+	}
+	err = p.setupRoutes()
+	if err != nil {
+		return err
+	}
+	err = p.createReverseProxy()
+	if err != nil {
+		return err
+	}
+	err = p.setupCertificate()
+	if err != nil {
+		return err
+	}
+	err = p.setupErrorPages()
+	if err != nil {
+		return err
+	}
+	err = p.setupHeaderRules()
+	if err != nil {
+		return err
+	}
+	p.setupRewrites()
+	p.startHealthCheck()
 	return nil
 }
 
 func Stop(config *config.Config) error {
 	defaultStateMap.quit()
+	for _, proxy := range proxies {
+		proxy.close()
+	}
+	clearDerivedProxies()
 	return nil
 }
 
 func GetProxy(requestHost string) *Proxy {
-	return proxyMap[requestHost]
+	requestHost = strings.ToLower(requestHost)
+	p := proxyMap[requestHost]
+	if p != nil {
+		return p
+	}
+	p = proxyMap[stripPort(requestHost)]
+	if p != nil {
+		return p
+	}
+	return getDerivedProxy(requestHost)
+}
+
+// ClientProxy returns the auth host or a proxy that uses the OAuth client clientID, or nil.
+func ClientProxy(clientID string) *Proxy {
+	if authProxy != nil && authProxy.ClientID == clientID {
+		return authProxy
+	}
+	for _, p := range proxyMap {
+		if p.ClientID == clientID {
+			return p
+		}
+	}
+	return nil
+}
+
+// AuthProxy returns the central auth host, or nil if auth_url is not configured.
+func AuthProxy() *Proxy {
+	return authProxy
+}
+
+func IsAuthHost(requestHost string) bool {
+	return authProxy != nil && SameHost(authProxy.RequestHost, requestHost)
+}
+
+// SameHost reports whether two hosts are equal, ignoring case and port.
+func SameHost(a, b string) bool {
+	return strings.EqualFold(stripPort(a), stripPort(b))
+}
+
+func setupAuthProxy() error {
+	authProxy = nil
+	if Config.AuthURL == "" {
+		return nil
+	}
+	authURL, err := url.Parse(Config.AuthURL)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if authURL.Host == "" {
+		return errors.Errorf("invalid auth_url: %s", Config.AuthURL)
+	}
+	if IsProxiedHost(authURL.Host) {
+		return errors.Errorf("auth host %s must not be a proxied host", authURL.Host)
+	}
+	p := &Proxy{
+		Scheme:      authURL.Scheme,
+		RequestHost: authURL.Host,
+	}
+	p.setupOAuth()
+	if !Config.SSO || !cookieDomainMatches(Config.CookieDomain, authURL.Host) {
+		p.CookieDomain = stripPort(authURL.Host)
+	}
+	err = p.setupCookie()
+	if err != nil {
+		return err
+	}
+	authProxy = p
+	return nil
 }
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"gottb.io/goru/log"
+)
+
+type retryTransport struct {
+	proxy     *Proxy
+	transport http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	c := requestUpstreamContext(req)
+	if c == nil {
+		return resp, err
+	}
+	tried := []*upstream{}
+	for attempt := 0; err != nil && attempt < t.proxy.Retries && isRetryable(req); attempt++ {
+		tried = append(tried, c.upstream)
+		c.upstream.markFailure(c.pool)
+		u := c.pool.pick(tried...)
+		if u == nil {
+			break
+		}
+		log.Warnf("Retrying %s %s%s on %s: %s", req.Method, t.proxy.RequestHost, c.requestURL.Path, u.target.String(), err)
+		atomic.AddInt64(&c.upstream.active, -1)
+		atomic.AddInt64(&u.active, 1)
+		c.upstream = u
+		retry := req.Clone(req.Context())
+		for _, rule := range t.proxy.RequestHeaders {
+			name := http.CanonicalHeaderKey(rule.Name)
+			value, ok := c.requestHeader[name]
+			if ok {
+				retry.Header[name] = append([]string{}, value...)
+			} else {
+				delete(retry.Header, name)
+			}
+		}
+		requestURL := *c.requestURL
+		retry.URL = &requestURL
+		retry.Host = c.requestHost
+		t.proxy.direct(retry)
+		resp, err = t.transport.RoundTrip(retry)
+	}
+	return resp, err
+}
+
+func isRetryable(req *http.Request) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (p *Proxy) setupRewrites() {
+	if p.RewriteLocation == nil {
+		rewrite := !p.PreserveHost
+		p.RewriteLocation = &rewrite
+	}
+	if p.RewriteCookieDomain == nil {
+		rewrite := !p.PreserveHost
+		p.RewriteCookieDomain = &rewrite
+	}
+}
+
+func (p *Proxy) rewriteLocation(resp *http.Response, c *upstreamContext) {
+	location := resp.Header.Get("Location")
+	if !*p.RewriteLocation || location == "" {
+		return
+	}
+	target, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	if target.Host != "" {
+		upstream := c.upstream.target
+		scheme := target.Scheme
+		if scheme == "" {
+			scheme = upstream.Scheme
+		}
+		if hostPort(scheme, target.Host) != hostPort(upstream.Scheme, upstream.Host) {
+			return
+		}
+		target.Scheme = p.Scheme
+		if target.Scheme == "" {
+			target.Scheme = c.requestScheme
+		}
+		target.Host = c.requestHost
+	} else if !strings.HasPrefix(target.Path, "/") {
+		return
+	}
+	path, ok := c.publicPath(target.Path)
+	if !ok {
+		return
+	}
+	target.Path = path
+	target.RawPath = ""
+	resp.Header.Set("Location", target.String())
+}
+
+func (p *Proxy) rewriteCookies(resp *http.Response, c *upstreamContext) {
+	cookies := resp.Header["Set-Cookie"]
+	if !*p.RewriteCookieDomain || len(cookies) == 0 {
+		return
+	}
+	upstreamHost := stripPort(c.upstream.target.Host)
+	publicHost := stripPort(c.requestHost)
+	for i, cookie := range cookies {
+		attributes := strings.Split(cookie, ";")
+		for j := 1; j < len(attributes); j++ {
+			pieces := strings.SplitN(strings.TrimSpace(attributes[j]), "=", 2)
+			if len(pieces) != 2 {
+				continue
+			}
+			switch strings.ToLower(pieces[0]) {
+			case "domain":
+				if strings.EqualFold(strings.TrimPrefix(pieces[1], "."), upstreamHost) {
+					attributes[j] = " Domain=" + publicHost
+				}
+			case "path":
+				path, ok := c.publicPath(pieces[1])
+				if ok {
+					attributes[j] = " Path=" + path
+				}
+			}
+		}
+		cookies[i] = strings.Join(attributes, ";")
+	}
+}
+
+func (c *upstreamContext) publicPath(path string) (string, bool) {
+	targetPath := strings.TrimSuffix(c.upstream.target.Path, "/")
+	if targetPath != "" {
+		if path != targetPath && !strings.HasPrefix(path, targetPath+"/") {
+			return path, false
+		}
+		path = strings.TrimPrefix(path, targetPath)
+	}
+	if c.route != nil && c.route.StripPrefix {
+		path = strings.TrimSuffix(c.route.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path, true
+}
+
+func hostPort(scheme, host string) string {
+	host = strings.ToLower(host)
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestContext(t *testing.T, endPoint string, route *Route) *upstreamContext {
+	target, err := url.Parse(endPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &upstreamContext{
+		upstream:      &upstream{target: target},
+		route:         route,
+		requestScheme: "http",
+		requestHost:   "www.your.server",
+	}
+}
+
+func TestPublicPath(t *testing.T) {
+	tests := []struct {
+		endPoint string
+		route    *Route
+		path     string
+		public   string
+		ok       bool
+	}{
+		{"http://backend", nil, "/", "/", true},
+		{"http://backend", nil, "/x", "/x", true},
+		{"http://backend/app", nil, "/app", "/", true},
+		{"http://backend/app/", nil, "/app/x", "/x", true},
+		{"http://backend/app", nil, "/other", "/other", false},
+		{"http://backend/app", nil, "/application", "/application", false},
+		{"http://backend", &Route{Path: "/api/"}, "/x", "/x", true},
+		{"http://backend", &Route{Path: "/api/", StripPrefix: true}, "/", "/api/", true},
+		{"http://backend", &Route{Path: "/api/", StripPrefix: true}, "/x", "/api/x", true},
+		{"http://backend/app", &Route{Path: "/api/", StripPrefix: true}, "/app/x", "/api/x", true},
+		{"http://backend/app", &Route{Path: "/api/", StripPrefix: true}, "/x", "/x", false},
+	}
+	for _, test := range tests {
+		c := newTestContext(t, test.endPoint, test.route)
+		public, ok := c.publicPath(test.path)
+		if public != test.public || ok != test.ok {
+			t.Errorf("publicPath(%q) with %s = %q, %v, want %q, %v", test.path, test.endPoint, public, ok, test.public, test.ok)
+		}
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		scheme   string
+		endPoint string
+		route    *Route
+		location string
+		rewrite  string
+	}{
+		{"", "http://backend", nil, "http://backend/x", "http://www.your.server/x"},
+		{"", "http://backend", nil, "http://BACKEND:80/x?y=1", "http://www.your.server/x?y=1"},
+		{"", "http://backend:80", nil, "http://backend/x", "http://www.your.server/x"},
+		{"", "https://backend", nil, "https://backend:443/x", "http://www.your.server/x"},
+		{"", "http://backend", nil, "//backend/x", "http://www.your.server/x"},
+		{"https", "http://backend", nil, "http://backend/x", "https://www.your.server/x"},
+		{"", "http://backend", nil, "http://backend:8080/x", "http://backend:8080/x"},
+		{"", "http://backend", nil, "https://backend/x", "https://backend/x"},
+		{"", "http://backend", nil, "http://other/x", "http://other/x"},
+		{"", "http://backend", nil, "/x", "/x"},
+		{"", "http://backend", nil, "x", "x"},
+		{"", "http://backend/app", nil, "http://backend/app/x", "http://www.your.server/x"},
+		{"", "http://backend/app", nil, "/app/x", "/x"},
+		{"", "http://backend/app", nil, "/other", "/other"},
+		{"", "http://backend", &Route{Path: "/api/"}, "/x", "/x"},
+		{"", "http://backend", &Route{Path: "/api/", StripPrefix: true}, "/x", "/api/x"},
+		{"", "http://backend", &Route{Path: "/api/", StripPrefix: true}, "http://backend/", "http://www.your.server/api/"},
+	}
+	for _, test := range tests {
+		p := &Proxy{Scheme: test.scheme, RewriteLocation: &enabled}
+		c := newTestContext(t, test.endPoint, test.route)
+		resp := &http.Response{Header: http.Header{"Location": {test.location}}}
+		p.rewriteLocation(resp, c)
+		if location := resp.Header.Get("Location"); location != test.rewrite {
+			t.Errorf("rewriteLocation(%q) with %s = %q, want %q", test.location, test.endPoint, location, test.rewrite)
+		}
+	}
+}
+
+func TestRewriteLocationRequestScheme(t *testing.T) {
+	enabled := true
+	p := &Proxy{RewriteLocation: &enabled}
+	tests := []struct {
+		request *http.Request
+		scheme  string
+	}{
+		{httptest.NewRequest("GET", "http://www.your.server/", nil), "http"},
+		{httptest.NewRequest("GET", "https://www.your.server/", nil), "https"},
+		{&http.Request{Header: http.Header{"X-Forwarded-Proto": {"https"}}}, "https"},
+	}
+	for _, test := range tests {
+		c := newTestContext(t, "http://backend", nil)
+		c.requestScheme = requestScheme(test.request)
+		resp := &http.Response{Header: http.Header{"Location": {"http://backend/x"}}}
+		p.rewriteLocation(resp, c)
+		if location := resp.Header.Get("Location"); location != test.scheme+"://www.your.server/x" {
+			t.Errorf("rewriteLocation with %s request = %q", test.scheme, location)
+		}
+	}
+}
+
+func TestRewriteCookies(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		endPoint string
+		route    *Route
+		cookie   string
+		rewrite  string
+	}{
+		{"http://backend", nil, "id=1; Path=/; Domain=backend", "id=1; Path=/; Domain=www.your.server"},
+		{"http://backend:8080", nil, "id=1; Domain=.BACKEND; HttpOnly", "id=1; Domain=www.your.server; HttpOnly"},
+		{"http://backend", nil, "id=1; Domain=other", "id=1; Domain=other"},
+		{"http://backend", nil, "domain=backend; Path=/", "domain=backend; Path=/"},
+		{"http://backend", nil, "path=/app; Domain=backend", "path=/app; Domain=www.your.server"},
+		{"http://backend/app", nil, "id=1; Path=/app/x", "id=1; Path=/x"},
+		{"http://backend/app", nil, "id=1; Path=/other", "id=1; Path=/other"},
+		{"http://backend", &Route{Path: "/api/"}, "id=1; Path=/x", "id=1; Path=/x"},
+		{"http://backend", &Route{Path: "/api/", StripPrefix: true}, "id=1; Path=/", "id=1; Path=/api/"},
+		{"http://backend", &Route{Path: "/api/", StripPrefix: true}, "id=1; Path=/x; Domain=backend", "id=1; Path=/api/x; Domain=www.your.server"},
+	}
+	for _, test := range tests {
+		p := &Proxy{RewriteCookieDomain: &enabled}
+		c := newTestContext(t, test.endPoint, test.route)
+		resp := &http.Response{Header: http.Header{"Set-Cookie": {test.cookie}}}
+		p.rewriteCookies(resp, c)
+		if cookie := resp.Header.Get("Set-Cookie"); cookie != test.rewrite {
+			t.Errorf("rewriteCookies(%q) with %s = %q, want %q", test.cookie, test.endPoint, cookie, test.rewrite)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"gottb.io/goru/errors"
+)
+
+// Route sends the requests under Path to its own upstreams.
+type Route struct {
+	Path          string   `config:"path"`
+	StripPrefix   bool     `config:"strip_prefix"`
+	EndPoint      string   `config:"end_point"`
+	EndPoints     []string `config:"end_points"`
+	Organizations []string `config:"organizations"`
+	Teams         []string `config:"teams"`
+	Whitelists    []string `config:"whitelists"`
+
+	*Access
+	upstreams *upstreamPool
+}
+
+func (r *Route) matches(path string) bool {
+	return strings.HasPrefix(path, r.Path) || path == strings.TrimSuffix(r.Path, "/")
+}
+
+func (r *Route) stripPrefix(req *http.Request) {
+	prefix := strings.TrimSuffix(r.Path, "/")
+	req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(cleanPath(req.URL.Path), prefix), "/")
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(cleanPath(req.URL.RawPath), prefix), "/")
+	}
+}
+
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (p *Proxy) setupRoutes() error {
+	for _, route := range p.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return errors.Errorf("invalid route path for %s: %q", p.RequestHost, route.Path)
+		}
+		organizations := route.Organizations
+		if len(organizations) == 0 {
+			organizations = p.Organizations
+		}
+		teams := route.Teams
+		if len(teams) == 0 {
+			teams = p.Teams
+		}
+		whitelists := route.Whitelists
+		if len(whitelists) == 0 {
+			whitelists = p.Whitelists
+		}
+		var err error
+		route.Access, err = newAccess(p.RequestHost+route.Path, organizations, teams, whitelists)
+		if err != nil {
+			return err
+		}
+		endPoints := []string{}
+		if route.EndPoint != "" {
+			endPoints = append(endPoints, route.EndPoint)
+		}
+		endPoints = append(endPoints, route.EndPoints...)
+		route.upstreams, err = newUpstreamPool(endPoints, p.Balance, p.MaxFails, p.FailTimeout, p.CircuitBreaker)
+		if err != nil {
+			return errors.Errorf("invalid upstream for %s%s: %s", p.RequestHost, route.Path, err)
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) route(path string) *Route {
+	path = cleanPath(path)
+	var best *Route
+	for _, route := range p.Routes {
+		if route.matches(path) && (best == nil || len(route.Path) > len(best.Path)) {
+			best = route
+		}
+	}
+	return best
+}
+
+// AccessFor returns the authorization rules that apply to path.
+func (p *Proxy) AccessFor(path string) *Access {
+	route := p.route(path)
+	if route != nil {
+		return route.Access
+	}
+	return p.Access
+}
+
+func (p *Proxy) IsWhiteList(method, path string) bool {
+	return p.AccessFor(path).IsWhiteList(method, cleanPath(path))
+}
+
+func (p *Proxy) upstreamPool(path string) *upstreamPool {
+	route := p.route(path)
+	if route != nil {
+		return route.upstreams
+	}
+	return p.upstreams
+}
+
+func (p *Proxy) upstreamPools() []*upstreamPool {
+	pools := []*upstreamPool{}
+	if p.upstreams != nil {
+		pools = append(pools, p.upstreams)
+	}
+	for _, route := range p.Routes {
+		pools = append(pools, route.upstreams)
+	}
+	return pools
+}
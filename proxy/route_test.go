@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	admin := &Route{Path: "/admin/"}
+	adminAPI := &Route{Path: "/admin/api/"}
+	p := &Proxy{Routes: []*Route{admin, adminAPI}}
+	tests := []struct {
+		path  string
+		route *Route
+	}{
+		{"/", nil},
+		{"/public/x", nil},
+		{"/admin", admin},
+		{"/admin/", admin},
+		{"/admin/x", admin},
+		{"//admin/x", admin},
+		{"/./admin/x", admin},
+		{"/public/../admin/x", admin},
+		{"/public/..//admin/x", admin},
+		{"/admin/api/x", adminAPI},
+		{"/admin/x/../api/y", adminAPI},
+		{"/admin/../public/x", nil},
+		{"/adminx", nil},
+	}
+	for _, test := range tests {
+		route := p.route(test.path)
+		if route != test.route {
+			t.Errorf("route(%q) = %v, want %v", test.path, route, test.route)
+		}
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	route := &Route{Path: "/admin/"}
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"/admin", "/"},
+		{"/admin/", "/"},
+		{"/admin/x", "/x"},
+		{"/admin/x/", "/x/"},
+		{"//admin/x", "/x"},
+		{"/public/../admin/x", "/x"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "http://www.your.server/", nil)
+		req.URL.Path = test.target
+		route.stripPrefix(req)
+		if req.URL.Path != test.path {
+			t.Errorf("stripPrefix(%q) = %q, want %q", test.target, req.URL.Path, test.path)
+		}
+	}
+}
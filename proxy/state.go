@@ -10,8 +10,8 @@ import (
 
 var defaultStateMap *stateMap
 
-func AddState(name string, proxy *Proxy, request *http.Request) {
-	defaultStateMap.add(name, proxy, request)
+func AddState(name string, proxy *Proxy, request *http.Request, codeVerifier string) *State {
+	return defaultStateMap.add(name, proxy, request, codeVerifier)
 }
 
 func GetState(name string) *State {
@@ -23,20 +23,28 @@ func AcquireState(name string) *State {
 }
 
 type UserInfo struct {
-	Name  string `json:"login"`
-	Email string `json:"email"`
+	Name          string   `json:"login"`
+	Email         string   `json:"email"`
+	Organizations []string `json:"organizations"`
+	Teams         []string `json:"teams"`
+	Token         string   `json:"token,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
 }
 
 type Session struct {
-	User    *UserInfo `json:"user"`
-	Version int64     `json:"version"`
+	User      *UserInfo `json:"user"`
+	Version   int64     `json:"version"`
+	CreatedAt int64     `json:"created_at"`
+	LastSeen  int64     `json:"last_seen"`
 }
 
 type State struct {
-	Name    string
-	Proxy   *Proxy
-	Request *http.Request
-	User    *UserInfo
+	Name         string
+	Proxy        *Proxy
+	Request      *http.Request
+	User         *UserInfo
+	CodeVerifier string
+	Expires      time.Time
 }
 
 type stateMap struct {
@@ -58,15 +66,19 @@ func newStateMap(stateTimeout int) *stateMap {
 	return m
 }
 
-func (m *stateMap) add(name string, proxy *Proxy, request *http.Request) {
+func (m *stateMap) add(name string, proxy *Proxy, request *http.Request, codeVerifier string) *State {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	log.Debugf("State add: %s to %s", name, request.URL.String())
-	m.white[name] = &State{
-		Name:    name,
-		Proxy:   proxy,
-		Request: request,
+	state := &State{
+		Name:         name,
+		Proxy:        proxy,
+		Request:      request,
+		CodeVerifier: codeVerifier,
+		Expires:      time.Now().Add(time.Duration(proxy.StateTimeout) * time.Second),
 	}
+	m.white[name] = state
+	return state
 }
 
 func (m *stateMap) get(name string) *State {
@@ -85,6 +97,15 @@ func (m *stateMap) acquire(name string) *State {
 }
 
 func (m *stateMap) getUnsafe(name string) *State {
+	state := m.lookupUnsafe(name)
+	if state != nil && time.Now().After(state.Expires) {
+		log.Debugf("State expired: %s", name)
+		return nil
+	}
+	return state
+}
+
+func (m *stateMap) lookupUnsafe(name string) *State {
 	log.Debugf("Getting state %s", name)
 	state := m.white[name]
 	if state != nil {
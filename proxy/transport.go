@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const (
+	protocolHTTP1 = "http1"
+	protocolHTTP2 = "http2"
+	protocolH2C   = "h2c"
+
+	defaultDialTimeout = 30
+)
+
+func (p *Proxy) createTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch strings.ToLower(p.Protocol) {
+	case "":
+	case protocolHTTP1:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+	case protocolHTTP2:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+	case protocolH2C:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, errors.Errorf("invalid protocol: %s", p.Protocol)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         p.TLSServerName,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+	if p.InsecureSkipVerify {
+		log.Warnf("TLS certificate verification of upstreams is disabled for %s", p.RequestHost)
+	}
+	if p.TLSCAFile != "" {
+		content, err := ioutil.ReadFile(p.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, errors.Errorf("no certificate found in %s", p.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if p.TLSClientCertFile != "" || p.TLSClientKeyFile != "" {
+		clientCert, err := LoadKeyPair(p.TLSClientCertFile, p.TLSClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert.Certificate(), nil
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+	if p.DialTimeout <= 0 {
+		p.DialTimeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(p.DialTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = time.Duration(p.ResponseHeaderTimeout) * time.Second
+	if p.IdleTimeout > 0 {
+		idleTimeout := time.Duration(p.IdleTimeout) * time.Second
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return newIdleConn(conn, idleTimeout), nil
+		}
+	}
+	return transport, nil
+}
+
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) *idleConn {
+	c := &idleConn{
+		Conn:    conn,
+		timeout: timeout,
+	}
+	c.extend()
+	return c
+}
+
+func (c *idleConn) extend() {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.extend()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.extend()
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const (
+	balanceRoundRobin       = "round_robin"
+	balanceLeastConnections = "least_connections"
+	defaultMaxFails         = 1
+	defaultFailTimeout      = 10
+)
+
+type upstreamContextKey struct{}
+
+type upstream struct {
+	target       *url.URL
+	active       int64
+	mutex        sync.Mutex
+	fails        int
+	ejectedUntil time.Time
+	tripped      bool
+	unhealthy    bool
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return !u.unhealthy && !now.Before(u.ejectedUntil)
+}
+
+func (u *upstream) healthy() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return !u.unhealthy
+}
+
+func (u *upstream) markFailure(pool *upstreamPool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.fails++
+	if u.fails >= pool.maxFails || (pool.circuitBreaker && u.tripped) {
+		log.Warnf("Ejecting upstream %s for %s after %d failures", u.target.String(), pool.failTimeout, u.fails)
+		u.ejectedUntil = time.Now().Add(pool.failTimeout)
+		u.fails = 0
+		u.tripped = true
+	}
+}
+
+func (u *upstream) markSuccess() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.fails = 0
+	u.tripped = false
+}
+
+type upstreamPool struct {
+	upstreams      []*upstream
+	balance        string
+	maxFails       int
+	failTimeout    time.Duration
+	circuitBreaker bool
+	next           uint64
+}
+
+func newUpstreamPool(endPoints []string, balance string, maxFails, failTimeout int, circuitBreaker bool) (*upstreamPool, error) {
+	if len(endPoints) == 0 {
+		return nil, errors.Errorf("at least one end point must be configured")
+	}
+	balance = strings.ToLower(balance)
+	switch balance {
+	case "":
+		balance = balanceRoundRobin
+	case balanceRoundRobin, balanceLeastConnections:
+	default:
+		return nil, errors.Errorf("invalid balance: %s", balance)
+	}
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+	if failTimeout <= 0 {
+		failTimeout = defaultFailTimeout
+	}
+	pool := &upstreamPool{
+		balance:        balance,
+		maxFails:       maxFails,
+		failTimeout:    time.Duration(failTimeout) * time.Second,
+		circuitBreaker: circuitBreaker,
+	}
+	for _, endPoint := range endPoints {
+		target, err := url.Parse(endPoint)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		pool.upstreams = append(pool.upstreams, &upstream{target: target})
+	}
+	return pool, nil
+}
+
+func (pool *upstreamPool) pick(tried ...*upstream) *upstream {
+	now := time.Now()
+	candidates := pool.candidates(tried, func(u *upstream) bool {
+		return u.available(now)
+	})
+	if len(candidates) == 0 && !pool.circuitBreaker {
+		candidates = pool.candidates(tried, (*upstream).healthy)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if pool.balance == balanceLeastConnections {
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active) {
+				best = u
+			}
+		}
+		return best
+	}
+	n := atomic.AddUint64(&pool.next, 1)
+	return candidates[(n-1)%uint64(len(candidates))]
+}
+
+func (pool *upstreamPool) candidates(tried []*upstream, usable func(*upstream) bool) []*upstream {
+	candidates := make([]*upstream, 0, len(pool.upstreams))
+	for _, u := range pool.upstreams {
+		if usable(u) && !containsUpstream(tried, u) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 && len(tried) > 0 {
+		return pool.candidates(nil, usable)
+	}
+	return candidates
+}
+
+func (pool *upstreamPool) available() bool {
+	now := time.Now()
+	for _, u := range pool.upstreams {
+		if u.healthy() && (!pool.circuitBreaker || u.available(now)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUpstream(upstreams []*upstream, u *upstream) bool {
+	for _, v := range upstreams {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) endPoints() []string {
+	endPoints := []string{}
+	if p.EndPoint != "" {
+		endPoints = append(endPoints, p.EndPoint)
+	}
+	return append(endPoints, p.EndPoints...)
+}
+
+type upstreamContext struct {
+	upstream      *upstream
+	pool          *upstreamPool
+	route         *Route
+	requestURL    *url.URL
+	requestScheme string
+	requestHost   string
+	requestHeader http.Header
+	user          *UserInfo
+}
+
+func (p *Proxy) serveUpstream(w http.ResponseWriter, r *http.Request, user *UserInfo) {
+	route := p.route(r.URL.Path)
+	pool := p.upstreams
+	if route != nil {
+		pool = route.upstreams
+	}
+	if pool == nil {
+		http.NotFound(w, r)
+		return
+	}
+	u := pool.pick()
+	if u == nil {
+		log.Errorf("No healthy upstream for %s%s", p.RequestHost, r.URL.Path)
+		p.RenderError(w, r, http.StatusServiceUnavailable)
+		return
+	}
+	RequestID(r)
+	c := &upstreamContext{
+		upstream:      u,
+		pool:          pool,
+		route:         route,
+		requestURL:    r.URL,
+		requestScheme: requestScheme(r),
+		requestHost:   r.Host,
+		user:          user,
+	}
+	if p.Retries > 0 {
+		c.requestHeader = r.Header.Clone()
+	}
+	atomic.AddInt64(&u.active, 1)
+	defer func() {
+		atomic.AddInt64(&c.upstream.active, -1)
+	}()
+	defer abortOnPanic(w)
+	ctx := context.WithValue(r.Context(), upstreamContextKey{}, c)
+	if p.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.RequestTimeout)*time.Second)
+		defer cancel()
+	}
+	p.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func abortOnPanic(w http.ResponseWriter) {
+	rv := recover()
+	if rv == nil {
+		return
+	}
+	if rv != http.ErrAbortHandler {
+		panic(rv)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hijacker.Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func requestUpstreamContext(r *http.Request) *upstreamContext {
+	c, _ := r.Context().Value(upstreamContextKey{}).(*upstreamContext)
+	return c
+}
+
+func (p *Proxy) handleResponse(resp *http.Response) error {
+	c := requestUpstreamContext(resp.Request)
+	if c != nil {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			c.upstream.markFailure(c.pool)
+		} else {
+			c.upstream.markSuccess()
+		}
+		p.rewriteLocation(resp, c)
+		p.rewriteCookies(resp, c)
+	}
+	if len(resp.Trailer) > 0 {
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	p.rewriteResponseHeaders(resp.Request, resp.Header)
+	return nil
+}
+
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	c := requestUpstreamContext(r)
+	switch {
+	case c == nil:
+		log.Errorf("Upstream of %s failed: %s", p.RequestHost, err)
+	case isCanceled(r, err):
+		log.Debugf("Request to upstream %s of %s canceled: %s", c.upstream.target.String(), p.RequestHost, err)
+	default:
+		log.Errorf("Upstream %s of %s failed: %s", c.upstream.target.String(), p.RequestHost, err)
+		c.upstream.markFailure(c.pool)
+	}
+	p.RenderError(w, r, errorStatus(err))
+}
+
+func isCanceled(r *http.Request, err error) bool {
+	return stderrors.Is(err, context.Canceled) || stderrors.Is(r.Context().Err(), context.Canceled)
+}
@@ -0,0 +1,461 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gottb.io/goru"
+)
+
+func newTestProxy(t *testing.T, backend http.Handler) *httptest.Server {
+	upstream := httptest.NewServer(backend)
+	t.Cleanup(upstream.Close)
+	return serveTestProxy(t, &Proxy{
+		Scheme:      "http",
+		RequestHost: "www.your.server",
+		EndPoint:    upstream.URL,
+	})
+}
+
+func serveTestProxy(t *testing.T, p *Proxy) *httptest.Server {
+	err := p.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.close)
+	router := goru.NewRouter()
+	router.Any("/**", goru.HandlerFunc(func(ctx *goru.Context) {
+		p.serveUpstream(ctx.ResponseWriter, ctx.Request, nil)
+	}))
+	front := httptest.NewServer(router)
+	t.Cleanup(front.Close)
+	return front
+}
+
+func TestServeUpstreamWebSocket(t *testing.T) {
+	front := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /socket HTTP/1.1\r\nHost: www.your.server\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	for _, message := range []string{"hello\n", "world\n"} {
+		fmt.Fprint(conn, message)
+		echo, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if echo != message {
+			t.Fatalf("echo = %q, want %q", echo, message)
+		}
+	}
+}
+
+func TestServeUpstreamEventStream(t *testing.T) {
+	next := make(chan bool)
+	front := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}))
+
+	resp, err := http.Get(front.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != fmt.Sprintf("data: %d\n", i) {
+			t.Fatalf("event %d = %q", i, line)
+		}
+		reader.ReadString('\n')
+		next <- true
+	}
+}
+
+func TestServeUpstreamAbortedStream(t *testing.T) {
+	front := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+
+	resp, err := http.Get(front.URL + "/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatal("truncated body was not aborted")
+	}
+	if strings.Contains(string(body), "Internal Server Error") {
+		t.Fatalf("error page appended to truncated body: %q", body)
+	}
+}
+
+func TestAbortOnPanicWithoutHijacker(t *testing.T) {
+	defer func() {
+		rv := recover()
+		if rv != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want %v", rv, http.ErrAbortHandler)
+		}
+	}()
+	func() {
+		defer abortOnPanic(httptest.NewRecorder())
+		panic(http.ErrAbortHandler)
+	}()
+}
+
+func TestServeUpstreamRetry(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Host", r.Host)
+		w.Header()["X-Seen-Trace"] = r.Header["X-Trace"]
+	}))
+	t.Cleanup(upstream.Close)
+	front := serveTestProxy(t, &Proxy{
+		Scheme:      "http",
+		RequestHost: "www.your.server",
+		EndPoints:   []string{"http://" + dead.Addr().String(), upstream.URL},
+		Retries:     1,
+		RequestHeaders: []*HeaderRule{
+			{Action: "append", Name: "X-Trace", Value: "proxy"},
+			{Action: "set", Name: "Host", Value: "backend.internal"},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", front.URL+"/", nil)
+		req.Header.Set("X-Trace", "client")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if host := resp.Header.Get("X-Seen-Host"); host != "backend.internal" {
+			t.Errorf("upstream got host %q, want backend.internal", host)
+		}
+		if trace := resp.Header["X-Seen-Trace"]; len(trace) != 2 || trace[0] != "client" || trace[1] != "proxy" {
+			t.Errorf("upstream got X-Trace %q, want [client proxy]", trace)
+		}
+	}
+}
+
+func TestRenderErrorResponseHeaders(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	front := serveTestProxy(t, &Proxy{
+		Scheme:      "http",
+		RequestHost: "www.your.server",
+		EndPoint:    "http://" + dead.Addr().String(),
+		ResponseHeaders: []*HeaderRule{
+			{Action: "set", Name: "X-Served-By", Value: "${host}"},
+		},
+	})
+
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+	if servedBy := resp.Header.Get("X-Served-By"); servedBy != "www.your.server" {
+		t.Errorf("X-Served-By = %q, want www.your.server", servedBy)
+	}
+}
+
+func waitIdle(t *testing.T, u *upstream) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&u.active) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("request to upstream did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeUpstreamCanceled(t *testing.T) {
+	received := make(chan bool)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			received <- true
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	p := &Proxy{
+		Scheme:         "http",
+		RequestHost:    "www.your.server",
+		EndPoint:       upstream.URL,
+		CircuitBreaker: true,
+	}
+	front := serveTestProxy(t, p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", front.URL+"/slow", nil)
+	go func() {
+		<-received
+		cancel()
+	}()
+	_, err := http.DefaultClient.Do(req)
+	if err == nil {
+		t.Fatal("canceled request succeeded")
+	}
+	u := p.upstreams.upstreams[0]
+	waitIdle(t, u)
+	if !u.available(time.Now()) {
+		t.Fatal("upstream ejected after a canceled request")
+	}
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func closeConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestServeUpstreamRequestTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	p := &Proxy{
+		Scheme:         "http",
+		RequestHost:    "www.your.server",
+		EndPoint:       upstream.URL,
+		RequestTimeout: 1,
+	}
+	front := serveTestProxy(t, p)
+
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+	u := p.upstreams.upstreams[0]
+	waitIdle(t, u)
+	if u.available(time.Now()) {
+		t.Fatal("upstream not ejected after a timeout")
+	}
+}
+
+func TestServeUpstreamRetryBudget(t *testing.T) {
+	tests := []struct {
+		method string
+		hits   int64
+	}{
+		{"GET", 3},
+		{"DELETE", 3},
+		{"POST", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			var hits int64
+			endPoints := []string{}
+			for i := 0; i < 4; i++ {
+				upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt64(&hits, 1)
+					closeConnection(w)
+				}))
+				t.Cleanup(upstream.Close)
+				endPoints = append(endPoints, upstream.URL)
+			}
+			front := serveTestProxy(t, &Proxy{
+				Scheme:      "http",
+				RequestHost: "www.your.server",
+				EndPoints:   endPoints,
+				Retries:     2,
+			})
+
+			req, _ := http.NewRequest(test.method, front.URL+"/", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+			}
+			if n := atomic.LoadInt64(&hits); n != test.hits {
+				t.Fatalf("%d upstream attempts, want %d", n, test.hits)
+			}
+		})
+	}
+}
+
+func TestServeUpstreamCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var hits int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			closeConnection(w)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	p := &Proxy{
+		Scheme:         "http",
+		RequestHost:    "www.your.server",
+		EndPoint:       upstream.URL,
+		MaxFails:       2,
+		FailTimeout:    1,
+		CircuitBreaker: true,
+	}
+	front := serveTestProxy(t, p)
+	u := p.upstreams.upstreams[0]
+	expect := func(step string, status int, hit bool) {
+		t.Helper()
+		before := atomic.LoadInt64(&hits)
+		resp, err := http.Get(front.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		waitIdle(t, u)
+		if resp.StatusCode != status {
+			t.Fatalf("%s: status = %d, want %d", step, resp.StatusCode, status)
+		}
+		if reached := atomic.LoadInt64(&hits) != before; reached != hit {
+			t.Fatalf("%s: upstream reached = %v, want %v", step, reached, hit)
+		}
+	}
+	waitEjection := func() {
+		u.mutex.Lock()
+		ejectedUntil := u.ejectedUntil
+		u.mutex.Unlock()
+		time.Sleep(time.Until(ejectedUntil) + 50*time.Millisecond)
+	}
+
+	expect("first failure", http.StatusBadGateway, true)
+	expect("second failure", http.StatusBadGateway, true)
+	expect("open", http.StatusServiceUnavailable, false)
+
+	waitEjection()
+	expect("half-open failure", http.StatusBadGateway, true)
+	expect("reopened", http.StatusServiceUnavailable, false)
+
+	waitEjection()
+	atomic.StoreInt32(&failing, 0)
+	expect("half-open success", http.StatusOK, true)
+	atomic.StoreInt32(&failing, 1)
+	expect("closed failure", http.StatusBadGateway, true)
+	expect("closed", http.StatusBadGateway, true)
+	expect("open again", http.StatusServiceUnavailable, false)
+}
+
+func TestServeUpstreamH2CTrailers(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("X-Seen-Proto", r.Proto)
+		w.Write([]byte("payload"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+	front := serveTestProxy(t, &Proxy{
+		Scheme:      "http",
+		RequestHost: "www.your.server",
+		EndPoint:    upstream.URL,
+		Protocol:    "h2c",
+	})
+
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "payload" {
+		t.Fatalf("status = %d, body = %q", resp.StatusCode, body)
+	}
+	if proto := resp.Header.Get("X-Seen-Proto"); proto != "HTTP/2.0" {
+		t.Errorf("upstream got %s, want HTTP/2.0", proto)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", status)
+	}
+}
@@ -0,0 +1,127 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"gottb.io/goru/config"
+	"gottb.io/goru/errors"
+)
+
+const (
+	formatVersion byte = 1
+)
+
+var ErrInvalidCiphertext = errors.Errorf("invalid ciphertext")
+
+var Config struct {
+	Secret  string   `config:"secret"`
+	Secrets []string `config:"secrets"`
+}
+
+var aeads []cipher.AEAD
+var signingKeys [][]byte
+
+func Start(config *config.Config) error {
+	generalConfig, err := config.Get("general")
+	if err != nil {
+		return err
+	}
+	err = generalConfig.Unmarshal(&Config)
+	if err != nil {
+		return err
+	}
+	secrets := Config.Secrets
+	if len(secrets) == 0 && Config.Secret != "" {
+		secrets = []string{Config.Secret}
+	}
+	if len(secrets) == 0 {
+		return errors.Errorf("secret string must be configured")
+	}
+	aeads = []cipher.AEAD{}
+	signingKeys = [][]byte{}
+	for _, secret := range secrets {
+		if secret == "" {
+			return errors.Errorf("secret string must not be empty")
+		}
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return err
+		}
+		aeads = append(aeads, aead)
+		signingKey := sha256.Sum256([]byte("sign:" + secret))
+		signingKeys = append(signingKeys, signingKey[:])
+	}
+	return nil
+}
+
+// Encrypt seals text with the first configured secret using AES-GCM.
+func Encrypt(text []byte) ([]byte, error) {
+	if len(aeads) == 0 {
+		return nil, errors.Errorf("secure package was not started")
+	}
+	aead := aeads[0]
+	nonceSize := aead.NonceSize()
+	out := make([]byte, 1+nonceSize, 1+nonceSize+len(text)+aead.Overhead())
+	out[0] = formatVersion
+	if _, err := io.ReadFull(rand.Reader, out[1:]); err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return aead.Seal(out, out[1:], text, out[:1]), nil
+}
+
+// Decrypt opens text with any of the configured secrets.
+func Decrypt(text []byte) ([]byte, error) {
+	if len(text) == 0 || text[0] != formatVersion {
+		return nil, ErrInvalidCiphertext
+	}
+	for _, aead := range aeads {
+		nonceSize := aead.NonceSize()
+		if len(text) < 1+nonceSize+aead.Overhead() {
+			return nil, ErrInvalidCiphertext
+		}
+		plain, err := aead.Open(nil, text[1:1+nonceSize], text[1+nonceSize:], text[:1])
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalidCiphertext
+}
+
+// Sign returns an HMAC-SHA256 of text keyed with the first configured secret.
+func Sign(text []byte) []byte {
+	return sign(signingKeys[0], text)
+}
+
+// Verify checks signature against every configured secret.
+func Verify(text, signature []byte) bool {
+	for _, key := range signingKeys {
+		if hmac.Equal(sign(key, text), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(key, text []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(text)
+	return mac.Sum(nil)
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,103 @@
+package secure
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gottb.io/goru/config/toml"
+)
+
+func startSecrets(t *testing.T, secrets ...string) {
+	quoted := make([]string, len(secrets))
+	for i, secret := range secrets {
+		quoted[i] = fmt.Sprintf("%q", secret)
+	}
+	conf, err := toml.Build(strings.NewReader("[general]\nsecrets = [" + strings.Join(quoted, ", ") + "]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	startSecrets(t, "old secret")
+	sealed, err := Encrypt([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	startSecrets(t, "new secret", "old secret")
+	rotated, err := Encrypt([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, rotated...)
+	tampered[len(tampered)-1] ^= 1
+	tamperedNonce := append([]byte{}, rotated...)
+	tamperedNonce[1] ^= 1
+	wrongVersion := append([]byte{}, rotated...)
+	wrongVersion[0] = formatVersion + 1
+
+	tests := []struct {
+		name  string
+		text  []byte
+		plain string
+		ok    bool
+	}{
+		{"round trip", rotated, "session", true},
+		{"rotated secret", sealed, "session", true},
+		{"tampered ciphertext", tampered, "", false},
+		{"tampered nonce", tamperedNonce, "", false},
+		{"wrong version", wrongVersion, "", false},
+		{"truncated", rotated[:len(rotated)-1], "", false},
+		{"version only", rotated[:1], "", false},
+		{"empty", nil, "", false},
+	}
+	for _, test := range tests {
+		plain, err := Decrypt(test.text)
+		if (err == nil) != test.ok || string(plain) != test.plain {
+			t.Errorf("%s: Decrypt = %q, %v", test.name, plain, err)
+		}
+	}
+
+	startSecrets(t, "new secret")
+	_, err = Decrypt(sealed)
+	if err == nil {
+		t.Error("Decrypt accepted a secret removed from the config")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	startSecrets(t, "old secret")
+	oldSignature := Sign([]byte("state"))
+	startSecrets(t, "new secret", "old secret")
+	signature := Sign([]byte("state"))
+	if bytes.Equal(signature, oldSignature) {
+		t.Fatal("Sign does not use the first secret")
+	}
+	tampered := append([]byte{}, signature...)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name      string
+		text      string
+		signature []byte
+		ok        bool
+	}{
+		{"valid", "state", signature, true},
+		{"rotated secret", "state", oldSignature, true},
+		{"tampered signature", "state", tampered, false},
+		{"truncated signature", "state", signature[:len(signature)-1], false},
+		{"empty signature", "state", nil, false},
+		{"other text", "other", signature, false},
+	}
+	for _, test := range tests {
+		if Verify([]byte(test.text), test.signature) != test.ok {
+			t.Errorf("%s: Verify = %v, want %v", test.name, !test.ok, test.ok)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"gottb.io/goru/errors"
+)
+
+const (
+	defaultACMECacheDir = "certs"
+)
+
+var acmeManager *autocert.Manager
+
+func setupACME() error {
+	acmeManager = nil
+	if !Config.ACME {
+		return nil
+	}
+	if Config.ACMECacheDir == "" {
+		Config.ACMECacheDir = defaultACMECacheDir
+	}
+	client := &acme.Client{
+		DirectoryURL: Config.ACMEDirectoryURL,
+	}
+	if Config.ACMECAFile != "" {
+		content, err := ioutil.ReadFile(Config.ACMECAFile)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return errors.Errorf("no certificate found in %s", Config.ACMECAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(Config.ACMECacheDir),
+		HostPolicy: acmeHostPolicy,
+		Email:      Config.ACMEEmail,
+		Client:     client,
+	}
+	return nil
+}
+
+func acmeHostPolicy(ctx context.Context, host string) error {
+	if !proxy.IsConfiguredHost(host) {
+		return errors.Errorf("acme is not enabled for %s", host)
+	}
+	return nil
+}
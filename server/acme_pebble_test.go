@@ -0,0 +1,117 @@
+//go:build pebble
+
+// Run against a local Pebble started with PEBBLE_VA_ALWAYS_VALID=1:
+//
+//	PEBBLE_CA_FILE=test/certs/pebble.minica.pem go test -tags pebble ./server/
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"gottb.io/goru/config/toml"
+)
+
+const pebbleHost = "acme.test"
+
+const pebbleConfig = `
+[oauth]
+provider = "github"
+client_id = "client"
+client_secret = "secret"
+callback_uri = "/oauth2/callback"
+state_timeout = 3600
+cookie_timeout = 3600
+cookie_name = "oauth-proxy"
+
+[[proxy]]
+scheme = "https"
+request_host = "%s"
+end_point = "http://127.0.0.1:1"
+
+[tls]
+addr = "%s"
+acme = true
+acme_email = "admin@your.server"
+acme_directory_url = "%s"
+acme_ca_file = "%s"
+acme_cache_dir = "%s"
+`
+
+// pebbleFinalize works around an incompatibility between Pebble v2.10.0 and the
+// vendored golang.org/x/crypto/acme: Pebble finalizes orders asynchronously and
+// answers finalize without a Location header, while CreateOrderCert polls the
+// order at the Location of that response. Without the shim issuance fails with
+// "tls: internal error". Let's Encrypt sends the header, so this test covers the
+// client path up to finalize and not how the client handles a missing Location.
+type pebbleFinalize struct {
+	transport http.RoundTripper
+}
+
+func (t *pebbleFinalize) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err == nil && strings.Contains(req.URL.Path, "/finalize-order/") && resp.Header.Get("Location") == "" {
+		orderURL := *req.URL
+		orderURL.Path = strings.Replace(orderURL.Path, "/finalize-order/", "/my-order/", 1)
+		resp.Header.Set("Location", orderURL.String())
+	}
+	return resp, err
+}
+
+func TestACMEWithPebble(t *testing.T) {
+	directoryURL := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directoryURL == "" {
+		directoryURL = "https://localhost:14000/dir"
+	}
+	caFile := os.Getenv("PEBBLE_CA_FILE")
+	if caFile == "" {
+		t.Fatal("PEBBLE_CA_FILE must point to the certificate Pebble listens with")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	conf, err := toml.Build(strings.NewReader(fmt.Sprintf(pebbleConfig, pebbleHost, addr, directoryURL, caFile, t.TempDir())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = proxy.Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Stop(conf)
+	Handle(http.NotFoundHandler())
+	err = Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Stop(conf)
+	acmeManager.Client.HTTPClient.Transport = &pebbleFinalize{acmeManager.Client.HTTPClient.Transport}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: pebbleHost, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	leaf := conn.ConnectionState().PeerCertificates[0]
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != pebbleHost {
+		t.Fatalf("certificate issued for %v, want %s", leaf.DNSNames, pebbleHost)
+	}
+	if !strings.Contains(leaf.Issuer.CommonName, "Pebble") {
+		t.Fatalf("certificate issued by %s, want Pebble", leaf.Issuer)
+	}
+
+	_, err = getCertificate(&tls.ClientHelloInfo{ServerName: "unknown.your.server"})
+	if err == nil {
+		t.Fatal("certificate issued for a host that is not configured")
+	}
+}
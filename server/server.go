@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"golang.org/x/crypto/acme"
+	"gottb.io/goru"
+	"gottb.io/goru/config"
+	"gottb.io/goru/config/toml"
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+)
+
+var Config struct {
+	Addr         string `config:"addr"`
+	CertFile     string `config:"cert_file"`
+	KeyFile      string `config:"key_file"`
+	RedirectAddr string `config:"redirect_addr"`
+
+	ACME             bool   `config:"acme"`
+	ACMEEmail        string `config:"acme_email"`
+	ACMEDirectoryURL string `config:"acme_directory_url"`
+	ACMECAFile       string `config:"acme_ca_file"`
+	ACMECacheDir     string `config:"acme_cache_dir"`
+}
+
+var handler http.Handler
+var defaultKeyPair *proxy.KeyPair
+var servers []*http.Server
+
+// Handle sets the handler served over HTTPS. It must be called before Start.
+func Handle(h http.Handler) {
+	handler = h
+}
+
+// Start listens for HTTPS as configured in the optional [tls] section.
+func Start(config *config.Config) error {
+	if goru.GetRunMode() == goru.CheckMode {
+		return nil
+	}
+	tlsConfig, err := config.Get("tls")
+	if errors.Is(err, toml.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = tlsConfig.Unmarshal(&Config)
+	if err != nil {
+		return err
+	}
+	if Config.Addr == "" {
+		return nil
+	}
+	if handler == nil {
+		return errors.Errorf("no handler to serve over https")
+	}
+	defaultKeyPair = nil
+	if Config.CertFile != "" || Config.KeyFile != "" {
+		defaultKeyPair, err = proxy.LoadKeyPair(Config.CertFile, Config.KeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	err = setupACME()
+	if err != nil {
+		return err
+	}
+	servers = []*http.Server{}
+	tlsServerConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+	if acmeManager != nil {
+		tlsServerConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+	err = listen(Config.Addr, handler, tlsServerConfig)
+	if err != nil {
+		return err
+	}
+	if Config.RedirectAddr != "" {
+		var redirectHandler http.Handler = http.HandlerFunc(redirectHTTPS)
+		if acmeManager != nil {
+			redirectHandler = acmeManager.HTTPHandler(redirectHandler)
+		}
+		err = listen(Config.RedirectAddr, redirectHandler, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Stop(config *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	servers = nil
+	return nil
+}
+
+func listen(addr string, h http.Handler, tlsConfig *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: tlsConfig,
+	}
+	servers = append(servers, srv)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Infof("Serving https on %s", addr)
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			log.Infof("Redirecting http on %s to https", addr)
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Server on %s stopped: %s", addr, err)
+		}
+	}()
+	return nil
+}
+
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate := proxy.Certificate(hello.ServerName)
+	if certificate != nil {
+		return certificate, nil
+	}
+	if acmeManager != nil && proxy.IsConfiguredHost(hello.ServerName) {
+		return acmeManager.GetCertificate(hello)
+	}
+	if defaultKeyPair != nil {
+		return defaultKeyPair.Certificate(), nil
+	}
+	return nil, errors.Errorf("no certificate for %s", hello.ServerName)
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, port, err := net.SplitHostPort(Config.Addr)
+	if err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
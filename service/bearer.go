@@ -0,0 +1,125 @@
+package service
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anduintransaction/oauth-proxy/provider"
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"gottb.io/goru"
+	"gottb.io/goru/log"
+)
+
+const (
+	bearerCacheTimeout        = 60 * time.Second
+	bearerFailureCacheTimeout = 10 * time.Second
+	bearerCacheSize           = 1024
+)
+
+type bearerEntry struct {
+	user    *proxy.UserInfo
+	expires time.Time
+}
+
+var bearerCache = make(map[[sha256.Size]byte]*bearerEntry)
+var bearerMutex sync.Mutex
+
+// BearerToken returns the token of an "Authorization: Bearer" header if the proxy accepts it.
+func BearerToken(ctx *goru.Context, prox *proxy.Proxy) string {
+	if !prox.AllowBearerToken {
+		return ""
+	}
+	authorization := ctx.Request.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[7:])
+}
+
+// CheckBearerToken verifies a token issued to the proxy's client and authorizes its user.
+func CheckBearerToken(ctx *goru.Context, prox *proxy.Proxy, token string) *proxy.UserInfo {
+	key := bearerCacheKey(prox, token)
+	user, ok := cachedBearerUser(key)
+	if !ok {
+		user = verifyBearerToken(prox, token)
+		cacheBearerUser(key, user)
+	}
+	if user == nil {
+		return nil
+	}
+	if !prox.AccessFor(ctx.Request.URL.Path).Authorize(user) {
+		log.Debugf("User %s is not authorized for %s", user, prox.RequestHost)
+		return nil
+	}
+	ctx.Request.Header.Del("Authorization")
+	return user
+}
+
+// IsGRPC reports whether request is a gRPC call.
+func IsGRPC(request *http.Request) bool {
+	return strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc")
+}
+
+func verifyBearerToken(prox *proxy.Proxy, token string) *proxy.UserInfo {
+	prov := provider.GetProvider(prox.Provider)
+	if prov == nil {
+		log.Errorf("Proxy provider not found: %s", prox.Provider)
+		return nil
+	}
+	err := prov.CheckToken(prox, token)
+	if err != nil {
+		log.Debugf("Invalid bearer token for %s: %s", prox.RequestHost, err)
+		return nil
+	}
+	user, err := prov.GetUser(token)
+	if err != nil {
+		log.Debugf("Invalid bearer token for %s: %s", prox.RequestHost, err)
+		return nil
+	}
+	return user
+}
+
+func bearerCacheKey(prox *proxy.Proxy, token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(prox.ClientID + ":" + token))
+}
+
+func cachedBearerUser(key [sha256.Size]byte) (*proxy.UserInfo, bool) {
+	bearerMutex.Lock()
+	defer bearerMutex.Unlock()
+	entry := bearerCache[key]
+	if entry == nil {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(bearerCache, key)
+		return nil, false
+	}
+	return entry.user, true
+}
+
+func cacheBearerUser(key [sha256.Size]byte, user *proxy.UserInfo) {
+	bearerMutex.Lock()
+	defer bearerMutex.Unlock()
+	now := time.Now()
+	if len(bearerCache) >= bearerCacheSize {
+		for key, entry := range bearerCache {
+			if now.After(entry.expires) {
+				delete(bearerCache, key)
+			}
+		}
+		if len(bearerCache) >= bearerCacheSize {
+			bearerCache = make(map[[sha256.Size]byte]*bearerEntry)
+		}
+	}
+	timeout := bearerCacheTimeout
+	if user == nil {
+		timeout = bearerFailureCacheTimeout
+	}
+	bearerCache[key] = &bearerEntry{
+		user:    user,
+		expires: now.Add(timeout),
+	}
+}
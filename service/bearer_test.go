@@ -0,0 +1,172 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"gottb.io/goru"
+	"gottb.io/goru/config/toml"
+)
+
+const bearerConfig = `
+[oauth]
+provider = "github"
+client_id = "client"
+client_secret = "secret"
+callback_uri = "/oauth2/callback"
+state_timeout = 3600
+cookie_timeout = 3600
+cookie_name = "oauth-proxy"
+
+[[proxy]]
+scheme = "http"
+request_host = "www.your.server"
+end_point = "http://127.0.0.1:1"
+organizations = ["your org"]
+allow_bearer_token = true
+
+[[proxy.routes]]
+path = "/admin/"
+end_point = "http://127.0.0.1:1"
+organizations = ["admin org"]
+`
+
+type githubUser struct {
+	client string
+	login  string
+	orgs   []string
+}
+
+var githubUsers = map[string]*githubUser{
+	"valid":   {"client", "alice", []string{"your org"}},
+	"admin":   {"client", "root", []string{"your org", "admin org"}},
+	"foreign": {"other", "bob", []string{"your org"}},
+	"outside": {"client", "eve", []string{"other org"}},
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+func fakeGithub(t *testing.T) {
+	transport := http.DefaultTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = transport
+	})
+	http.DefaultTransport = &handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/applications/") {
+			body := struct {
+				AccessToken string `json:"access_token"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			clientID, secret, _ := r.BasicAuth()
+			user := githubUsers[body.AccessToken]
+			if secret != "secret" || r.URL.Path != "/applications/"+clientID+"/token" || user == nil || user.client != clientID {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("{}"))
+			return
+		}
+		user := githubUsers[strings.TrimPrefix(r.Header.Get("Authorization"), "token ")]
+		if user == nil {
+			http.Error(w, "{}", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/user":
+			json.NewEncoder(w).Encode(map[string]string{"login": user.login})
+		case "/user/orgs":
+			orgs := []map[string]string{}
+			for _, org := range user.orgs {
+				orgs = append(orgs, map[string]string{"login": org})
+			}
+			json.NewEncoder(w).Encode(orgs)
+		default:
+			http.NotFound(w, r)
+		}
+	})}
+}
+
+func startBearerProxy(t *testing.T) *proxy.Proxy {
+	conf, err := toml.Build(strings.NewReader(bearerConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = proxy.Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		proxy.Stop(conf)
+	})
+	bearerCache = make(map[[sha256.Size]byte]*bearerEntry)
+	return proxy.GetProxy("www.your.server")
+}
+
+func checkBearer(prox *proxy.Proxy, token, path string) *proxy.UserInfo {
+	req := httptest.NewRequest("GET", "http://www.your.server"+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	ctx := &goru.Context{Request: req, ResponseWriter: httptest.NewRecorder()}
+	return CheckBearerToken(ctx, prox, BearerToken(ctx, prox))
+}
+
+func TestCheckBearerToken(t *testing.T) {
+	prox := startBearerProxy(t)
+	fakeGithub(t)
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		login string
+	}{
+		{"valid", "valid", "/", "alice"},
+		{"valid from cache", "valid", "/page", "alice"},
+		{"foreign client", "foreign", "/", ""},
+		{"unauthorized", "outside", "/", ""},
+		{"unknown", "unknown", "/", ""},
+		{"route denied", "valid", "/admin/", ""},
+		{"route allowed", "admin", "/admin/", "root"},
+	}
+	for _, test := range tests {
+		user := checkBearer(prox, test.token, test.path)
+		switch {
+		case test.login == "" && user != nil:
+			t.Errorf("%s: token accepted for %s", test.name, user.Name)
+		case test.login != "" && user == nil:
+			t.Errorf("%s: token rejected", test.name)
+		case user != nil && user.Name != test.login:
+			t.Errorf("%s: user = %s, want %s", test.name, user.Name, test.login)
+		}
+	}
+}
+
+func TestCheckBearerTokenAuthorizesEachPath(t *testing.T) {
+	prox := startBearerProxy(t)
+	fakeGithub(t)
+
+	if user := checkBearer(prox, "valid", "/admin/"); user != nil {
+		t.Fatalf("token of %s accepted on /admin/", user.Name)
+	}
+	if user := checkBearer(prox, "valid", "/"); user == nil {
+		t.Fatal("denial on /admin/ reused for /")
+	}
+	if user := checkBearer(prox, "admin", "/admin/"); user == nil {
+		t.Fatal("admin token rejected on /admin/")
+	}
+	if user := checkBearer(prox, "admin", "/"); user == nil {
+		t.Fatal("admin token rejected on /")
+	}
+}
@@ -2,13 +2,29 @@ package service
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"net/url"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
 )
 
 func Version() string {
 	return "0.5.0"
 }
 
+func AuthURL(prox *proxy.Proxy, path, stateName string) string {
+	authURL := url.URL{
+		Scheme: prox.Scheme,
+		Host:   prox.RequestHost,
+		Path:   path,
+	}
+	values := make(url.Values)
+	values.Set("state", stateName)
+	authURL.RawQuery = values.Encode()
+	return authURL.String()
+}
+
 func generateRandomState() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
@@ -17,3 +33,12 @@ func generateRandomState() (string, error) {
 	}
 	return fmt.Sprintf("%x", b), nil
 }
+
+func generateCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
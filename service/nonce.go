@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/secure"
+	"gottb.io/goru"
+	"gottb.io/goru/log"
+)
+
+const noncePrefixLength = 8
+
+// SetLoginNonce binds a login state to the browser that started it.
+func SetLoginNonce(ctx *goru.Context, prox *proxy.Proxy, stateName string) {
+	signature := secure.Sign([]byte(stateName))
+	value := stateName + "." + base64.RawURLEncoding.EncodeToString(signature)
+	expires := time.Now().Add(time.Duration(prox.StateTimeout) * time.Second)
+	goru.SetCookie(ctx, newNonceCookie(prox, stateName, value, expires))
+}
+
+func CheckLoginNonce(ctx *goru.Context, prox *proxy.Proxy, stateName string) bool {
+	cookie, err := ctx.Request.Cookie(nonceCookieName(prox, stateName))
+	if err != nil {
+		log.Errorf("Login nonce not found for state %s", stateName)
+		return false
+	}
+	pieces := strings.SplitN(cookie.Value, ".", 2)
+	if len(pieces) != 2 || pieces[0] != stateName {
+		log.Errorf("Login nonce does not match state %s", stateName)
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(pieces[1])
+	if err != nil || !secure.Verify([]byte(pieces[0]), signature) {
+		log.Errorf("Invalid login nonce signature for state %s", stateName)
+		return false
+	}
+	return true
+}
+
+func ClearLoginNonce(ctx *goru.Context, prox *proxy.Proxy, stateName string) {
+	cookie := newNonceCookie(prox, stateName, "", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	goru.SetCookie(ctx, cookie)
+}
+
+func newNonceCookie(prox *proxy.Proxy, stateName, value string, expires time.Time) *http.Cookie {
+	cookie := prox.NewCookie(nonceCookieName(prox, stateName), value, expires)
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+func nonceCookieName(prox *proxy.Proxy, stateName string) string {
+	if len(stateName) > noncePrefixLength {
+		stateName = stateName[:noncePrefixLength]
+	}
+	return prox.CookieName + "_nonce_" + stateName
+}
@@ -3,15 +3,18 @@ package service
 import (
 	"encoding/base64"
 	"encoding/json"
+	"time"
 
 	"github.com/anduintransaction/oauth-proxy/provider"
 	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/secure"
 	"gottb.io/goru"
-	"gottb.io/goru/crypto"
 	"gottb.io/goru/errors"
 	"gottb.io/goru/log"
 )
 
+const maxCookieSize = 4096
+
 func DoRedirect(ctx *goru.Context, prox *proxy.Proxy) {
 	prov := provider.GetProvider(prox.Provider)
 	if prov == nil {
@@ -25,8 +28,20 @@ func DoRedirect(ctx *goru.Context, prox *proxy.Proxy) {
 		goru.InternalServerError(ctx, []byte("InternalServerError"))
 		return
 	}
-	proxy.AddState(randomState, prox, ctx.Request)
-	redirectURI := prov.RedirectURI(prox, randomState)
+	codeVerifier, err := generateCodeVerifier()
+	if err != nil {
+		log.Error(err)
+		goru.InternalServerError(ctx, []byte("InternalServerError"))
+		return
+	}
+	state := proxy.AddState(randomState, prox, ctx.Request, codeVerifier)
+	SetLoginNonce(ctx, prox, randomState)
+	authProxy := proxy.AuthProxy()
+	if authProxy != nil {
+		goru.Redirect(ctx, AuthURL(authProxy, "/oauth2/sso", randomState))
+		return
+	}
+	redirectURI := prov.RedirectURI(state)
 	goru.Redirect(ctx, redirectURI)
 }
 
@@ -34,8 +49,56 @@ func CheckWhitelist(ctx *goru.Context, prox *proxy.Proxy) bool {
 	return prox.IsWhiteList(ctx.Request.Method, ctx.Request.URL.Path)
 }
 
-func CheckSession(ctx *goru.Context) *proxy.UserInfo {
-	authCookie, err := ctx.Request.Cookie(proxy.Config.CookieName)
+func SetSession(ctx *goru.Context, prox *proxy.Proxy, user *proxy.UserInfo) error {
+	now := time.Now().Unix()
+	session := &proxy.Session{
+		User:      proxy.Referenced(user),
+		Version:   proxy.Config.Version,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	return writeSession(ctx, prox, session)
+}
+
+func ClearSession(ctx *goru.Context, prox *proxy.Proxy) {
+	cookie := prox.NewCookie(prox.CookieName, "", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	goru.SetCookie(ctx, cookie)
+}
+
+func CheckSession(ctx *goru.Context, prox *proxy.Proxy) *proxy.UserInfo {
+	session := loadSession(ctx, prox)
+	if session == nil {
+		return nil
+	}
+	if !prox.AccessFor(ctx.Request.URL.Path).Authorize(session.User) {
+		log.Debugf("User %s is not authorized for %s", session.User, prox.RequestHost)
+		return nil
+	}
+	renewSession(ctx, prox, session)
+	return session.User
+}
+
+func CheckAuthSession(ctx *goru.Context, prox *proxy.Proxy) *proxy.UserInfo {
+	session := loadSession(ctx, prox)
+	if session == nil {
+		return nil
+	}
+	renewSession(ctx, prox, session)
+	return session.User
+}
+
+// SessionUser returns the user of a valid session without renewing it.
+func SessionUser(ctx *goru.Context, prox *proxy.Proxy) *proxy.UserInfo {
+	session := loadSession(ctx, prox)
+	if session == nil {
+		return nil
+	}
+	return session.User
+}
+
+func loadSession(ctx *goru.Context, prox *proxy.Proxy) *proxy.Session {
+	authCookie, err := ctx.Request.Cookie(prox.CookieName)
 	if err != nil {
 		log.Error(errors.Wrap(err))
 		return nil
@@ -45,7 +108,7 @@ func CheckSession(ctx *goru.Context) *proxy.UserInfo {
 		log.Error(errors.Wrap(err))
 		return nil
 	}
-	decrypted, err := crypto.Decrypt(encrypted)
+	decrypted, err := secure.Decrypt(encrypted)
 	if err != nil {
 		log.Error(err)
 		return nil
@@ -56,12 +119,72 @@ func CheckSession(ctx *goru.Context) *proxy.UserInfo {
 		log.Error(errors.Wrap(err))
 		return nil
 	}
+	if session.User == nil {
+		return nil
+	}
 	log.Debugf("Got session for user %s", session.User)
 	if proxy.Config.CheckVersion && session.Version != proxy.Config.Version {
 		log.Debugf("Wrong version with user %s, expect %d but got %d", session.User, proxy.Config.Version, session.Version)
 		return nil
 	}
-	return session.User
+	now := time.Now().Unix()
+	if now-session.CreatedAt > sessionLifetime(prox) {
+		log.Debugf("Session of user %s reached its maximum lifetime", session.User)
+		return nil
+	}
+	if *prox.SessionIdleTimeout > 0 && now-session.LastSeen > int64(*prox.SessionIdleTimeout) {
+		log.Debugf("Session of user %s was idle for too long", session.User)
+		return nil
+	}
+	return session
+}
+
+func renewSession(ctx *goru.Context, prox *proxy.Proxy, session *proxy.Session) {
+	if *prox.SessionIdleTimeout <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	if now-session.LastSeen < int64(*prox.SessionIdleTimeout/10) {
+		return
+	}
+	session.LastSeen = now
+	err := writeSession(ctx, prox, session)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func writeSession(ctx *goru.Context, prox *proxy.Proxy, session *proxy.Session) error {
+	cookieContent, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	encryptedContent, err := secure.Encrypt(cookieContent)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(time.Duration(prox.CookieTimeout) * time.Second)
+	maxExpires := time.Unix(session.CreatedAt+sessionLifetime(prox), 0)
+	if maxExpires.Before(expires) {
+		expires = maxExpires
+	}
+	cookie := prox.NewCookie(
+		prox.CookieName,
+		base64.StdEncoding.EncodeToString(encryptedContent),
+		expires,
+	)
+	if len(cookie.String()) > maxCookieSize {
+		return errors.Errorf("session cookie for %s is %d bytes, more than %d", prox.RequestHost, len(cookie.String()), maxCookieSize)
+	}
+	goru.SetCookie(ctx, cookie)
+	return nil
+}
+
+func sessionLifetime(prox *proxy.Proxy) int64 {
+	if *prox.SessionMaxLifetime > 0 {
+		return int64(*prox.SessionMaxLifetime)
+	}
+	return int64(prox.CookieTimeout)
 }
 
 func ReverseProxy(ctx *goru.Context, prox *proxy.Proxy, user *proxy.UserInfo) {
@@ -70,5 +193,5 @@ func ReverseProxy(ctx *goru.Context, prox *proxy.Proxy, user *proxy.UserInfo) {
 		ctx.Request.Header.Add("X-Forwarded-Email", user.Email)
 	}
 	log.Debugf("Reverse proxy for %s to %s", prox.RequestHost, ctx.Request.URL.String())
-	prox.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+	prox.Serve(ctx.ResponseWriter, ctx.Request, user)
 }
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+)
+
+// IsSafeRedirect accepts relative paths and http(s) URLs on one of domains.
+func IsSafeRedirect(target string, domains []string) bool {
+	if isRelativeRedirect(target) {
+		return true
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+		return false
+	}
+	if targetURL.User != nil {
+		return false
+	}
+	return isAllowedDomain(targetURL.Hostname(), domains)
+}
+
+// LoginRedirect returns where the browser goes once the login of state is done.
+func LoginRedirect(state *proxy.State, absolute bool) string {
+	target := state.Request.URL.String()
+	if !IsSafeRedirect(target, state.Proxy.RedirectDomains) {
+		target = "/"
+	}
+	if !absolute || !isRelativeRedirect(target) {
+		return target
+	}
+	return state.Proxy.Scheme + "://" + state.Proxy.RequestHost + target
+}
+
+func isRelativeRedirect(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return false
+	}
+	if strings.ContainsAny(target, "\r\n\t") {
+		return false
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return targetURL.Scheme == "" && targetURL.Host == ""
+}
+
+func isAllowedDomain(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	if host == "" {
+		return false
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if host == strings.TrimPrefix(domain, ".") {
+			return true
+		}
+		if strings.HasPrefix(domain, ".") && strings.HasSuffix(host, domain) {
+			return true
+		}
+	}
+	return false
+}
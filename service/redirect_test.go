@@ -0,0 +1,59 @@
+package service
+
+import "testing"
+
+func TestIsSafeRedirect(t *testing.T) {
+	domains := []string{"www.your.server", ".apps.your.server"}
+	tests := []struct {
+		target string
+		safe   bool
+	}{
+		{"/", true},
+		{"/path?query=1#fragment", true},
+		{"//evil.com", false},
+		{"//evil.com/path", false},
+		{"/\\evil.com", false},
+		{"/\\/evil.com", false},
+		{"/path\twith\ttab", false},
+		{"/\t/evil.com", false},
+		{"/path\r\nSet-Cookie: a=b", false},
+		{"/path\nLocation: https://evil.com", false},
+		{"/%zz", false},
+		{"path", false},
+		{"javascript:alert(1)", false},
+		{"https://www.your.server/path", true},
+		{"http://WWW.YOUR.SERVER/path", true},
+		{"https://user@www.your.server/path", false},
+		{"https://user@evil.com", false},
+		{"https://www.your.server@evil.com", false},
+		{"https://evil.com", false},
+		{"https://www.your.server.evil.com", false},
+		{"https://apps.your.server", true},
+		{"https://wiki.apps.your.server/path", true},
+		{"https://wikiapps.your.server", false},
+		{"https://evilapps.your.server", false},
+		{"ftp://www.your.server", false},
+	}
+	for _, test := range tests {
+		safe := IsSafeRedirect(test.target, domains)
+		if safe != test.safe {
+			t.Errorf("IsSafeRedirect(%q) = %v, want %v", test.target, safe, test.safe)
+		}
+	}
+}
+
+func TestIsSafeRedirectWithoutDomains(t *testing.T) {
+	tests := []struct {
+		target string
+		safe   bool
+	}{
+		{"/path", true},
+		{"https://www.your.server", false},
+	}
+	for _, test := range tests {
+		safe := IsSafeRedirect(test.target, nil)
+		if safe != test.safe {
+			t.Errorf("IsSafeRedirect(%q) = %v, want %v", test.target, safe, test.safe)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"net/url"
+
+	"github.com/anduintransaction/oauth-proxy/provider"
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"gottb.io/goru/log"
+)
+
+func RevokeToken(prox *proxy.Proxy, user *proxy.UserInfo) {
+	if user == nil || user.Token == "" {
+		return
+	}
+	if user.ClientID != "" && user.ClientID != prox.ClientID {
+		prox = proxy.ClientProxy(user.ClientID)
+		if prox == nil {
+			log.Errorf("Client of token not found: %s", user.ClientID)
+			return
+		}
+	}
+	prov := provider.GetProvider(prox.Provider)
+	if prov == nil {
+		log.Errorf("Proxy provider not found: %s", prox.Provider)
+		return
+	}
+	err := prov.RevokeToken(prox, user.Token)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// SignOutTarget returns rd if it is a safe sign out redirect, otherwise sign_out_url.
+func SignOutTarget(rd string) string {
+	if rd != "" && isAllowedSignOutRedirect(rd) {
+		return rd
+	}
+	return proxy.Config.SignOutURL
+}
+
+func isAllowedSignOutRedirect(rd string) bool {
+	if IsSafeRedirect(rd, proxy.Config.SignOutRedirects) {
+		return true
+	}
+	target, err := url.Parse(rd)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.User != nil {
+		return false
+	}
+	return proxy.IsProxiedHost(target.Host)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/anduintransaction/oauth-proxy/proxy"
+	"github.com/anduintransaction/oauth-proxy/secure"
+	"gottb.io/goru"
+	"gottb.io/goru/errors"
+	"gottb.io/goru/log"
+)
+
+const ticketTimeout = 60 * time.Second
+
+type ticket struct {
+	State   string `json:"state"`
+	Host    string `json:"host"`
+	Expires int64  `json:"expires"`
+}
+
+// IssueTicket sends the browser back to the host that started the login with a one-time ticket.
+func IssueTicket(ctx *goru.Context, state *proxy.State) {
+	content, err := json.Marshal(&ticket{
+		State:   state.Name,
+		Host:    state.Proxy.RequestHost,
+		Expires: time.Now().Add(ticketTimeout).Unix(),
+	})
+	if err != nil {
+		log.Error(errors.Wrap(err))
+		goru.InternalServerError(ctx, []byte("InternalServerError"))
+		return
+	}
+	encrypted, err := secure.Encrypt(content)
+	if err != nil {
+		log.Error(err)
+		goru.InternalServerError(ctx, []byte("InternalServerError"))
+		return
+	}
+	redirectURL := url.URL{
+		Scheme: state.Proxy.Scheme,
+		Host:   state.Proxy.RequestHost,
+		Path:   "/oauth2/ticket",
+	}
+	values := make(url.Values)
+	values.Set("ticket", base64.RawURLEncoding.EncodeToString(encrypted))
+	redirectURL.RawQuery = values.Encode()
+	goru.Redirect(ctx, redirectURL.String())
+}
+
+func RedeemTicket(value, requestHost string) (*proxy.State, error) {
+	encrypted, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	content, err := secure.Decrypt(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	t := &ticket{}
+	err = json.Unmarshal(content, t)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if !proxy.SameHost(t.Host, requestHost) {
+		return nil, errors.Errorf("ticket for %s redeemed on %s", t.Host, requestHost)
+	}
+	if time.Now().Unix() > t.Expires {
+		return nil, errors.Errorf("ticket for state %s expired", t.State)
+	}
+	state := proxy.AcquireState(t.State)
+	if state == nil {
+		return nil, errors.Errorf("state not found or already used: %s", t.State)
+	}
+	if state.User == nil || !proxy.SameHost(state.Proxy.RequestHost, requestHost) {
+		return nil, errors.Errorf("invalid ticket state: %s", t.State)
+	}
+	return state, nil
+}